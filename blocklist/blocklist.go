@@ -0,0 +1,218 @@
+package blocklist
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/idna"
+)
+
+var ErrBlocked = errors.New("blocklist.go: destination is blocked")
+
+type rule struct {
+	// domain matches the domain and all of its subdomains
+	domain string
+	// wildcard rules ("*.example.com") only match subdomains
+	subdomainsOnly bool
+	// regex rules ("re:...") match against the full url
+	regex *regexp.Regexp
+}
+
+func (r rule) matches(host string, rawUrl string) bool {
+	if r.regex != nil {
+		return r.regex.MatchString(rawUrl)
+	}
+	if host == r.domain {
+		return !r.subdomainsOnly
+	}
+	return strings.HasSuffix(host, "."+r.domain)
+}
+
+// List holds destination rules loaded from a local file. Each line is one of
+//
+//	example.com          block example.com and its subdomains
+//	*.example.com        block subdomains of example.com only
+//	re:^https?://.*\.zip block urls matching a regular expression
+//	link:<id>            block a single short url without deleting it
+//	allow:<rule>         exception that overrides matching block rules
+//
+// Empty lines and lines starting with # are ignored. If requireAllow is set
+// only destinations matching an allow rule can be used.
+type List struct {
+	path         string
+	requireAllow bool
+	logger       *zap.Logger
+
+	lock    sync.RWMutex
+	blocked []rule
+	allowed []rule
+	links   map[string]struct{}
+	modTime time.Time
+}
+
+func New(path string, requireAllow bool, logger *zap.Logger) (*List, error) {
+	l := &List{
+		path:         path,
+		requireAllow: requireAllow,
+		logger:       logger,
+		links:        make(map[string]struct{}),
+	}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Reload re-reads the rules file. The current rules are kept if the file
+// can't be parsed.
+func (l *List) Reload() error {
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var blocked, allowed []rule
+	links := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if id, ok := strings.CutPrefix(line, "link:"); ok {
+			links[strings.TrimSpace(id)] = struct{}{}
+			continue
+		}
+
+		allow := false
+		if rest, ok := strings.CutPrefix(line, "allow:"); ok {
+			allow = true
+			line = strings.TrimSpace(rest)
+		}
+
+		r, err := parseRule(line)
+		if err != nil {
+			return fmt.Errorf("blocklist.go: line %d: %w", lineNum, err)
+		}
+		if allow {
+			allowed = append(allowed, r)
+		} else {
+			blocked = append(blocked, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.blocked = blocked
+	l.allowed = allowed
+	l.links = links
+	l.modTime = info.ModTime()
+	return nil
+}
+
+func parseRule(line string) (rule, error) {
+	if expr, ok := strings.CutPrefix(line, "re:"); ok {
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return rule{}, err
+		}
+		return rule{regex: regex}, nil
+	}
+
+	r := rule{}
+	if domain, ok := strings.CutPrefix(line, "*."); ok {
+		r.subdomainsOnly = true
+		line = domain
+	}
+	domain, err := idna.Lookup.ToASCII(strings.ToLower(strings.TrimSuffix(line, ".")))
+	if err != nil {
+		return rule{}, err
+	}
+	r.domain = domain
+	return r, nil
+}
+
+// Watch polls the rules file and reloads it whenever it changes, until stop
+// is closed.
+func (l *List) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(l.path)
+			if err != nil {
+				l.logger.Error("blocklist.go: unable to stat blocklist", zap.Error(err))
+				continue
+			}
+
+			l.lock.RLock()
+			changed := !info.ModTime().Equal(l.modTime)
+			l.lock.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err := l.Reload(); err != nil {
+				l.logger.Error("blocklist.go: unable to reload blocklist", zap.Error(err))
+				continue
+			}
+			l.logger.Info("blocklist.go: reloaded blocklist")
+		}
+	}
+}
+
+// CheckUrl returns ErrBlocked if the destination url may not be used.
+func (l *List) CheckUrl(rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(u.Hostname())
+
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	for _, r := range l.allowed {
+		if r.matches(host, rawUrl) {
+			return nil
+		}
+	}
+	if l.requireAllow {
+		return ErrBlocked
+	}
+	for _, r := range l.blocked {
+		if r.matches(host, rawUrl) {
+			return ErrBlocked
+		}
+	}
+	return nil
+}
+
+// IsLinkBlocked reports whether the short url id has been blocked directly.
+func (l *List) IsLinkBlocked(id string) bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	_, ok := l.links[id]
+	return ok
+}
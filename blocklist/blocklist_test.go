@@ -0,0 +1,89 @@
+package blocklist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testRules = `
+# phishing domains
+evil.com
+*.example.org
+re:^https?://[^/]+/.*\.exe$
+allow:good.evil.com
+link:MA==
+`
+
+func writeRules(t *testing.T, path string, rules string) {
+	require.NoError(t, os.WriteFile(path, []byte(rules), 0o600))
+}
+
+func TestCheckUrl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeRules(t, path, testRules)
+
+	l, err := New(path, false, zap.NewNop())
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, l.CheckUrl("https://evil.com/"), ErrBlocked)
+	assert.ErrorIs(t, l.CheckUrl("https://login.evil.com/"), ErrBlocked)
+	assert.ErrorIs(t, l.CheckUrl("https://www.example.org/"), ErrBlocked)
+	assert.ErrorIs(t, l.CheckUrl("https://files.com/setup.exe"), ErrBlocked)
+
+	assert.NoError(t, l.CheckUrl("https://good.evil.com/"))
+	assert.NoError(t, l.CheckUrl("https://example.org/"))
+	assert.NoError(t, l.CheckUrl("https://notevil.com/"))
+	assert.NoError(t, l.CheckUrl("https://files.com/setup.txt"))
+
+	assert.True(t, l.IsLinkBlocked("MA=="))
+	assert.False(t, l.IsLinkBlocked("MQ=="))
+}
+
+func TestRequireAllow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeRules(t, path, "allow:company.com\n")
+
+	l, err := New(path, true, zap.NewNop())
+	require.NoError(t, err)
+
+	assert.NoError(t, l.CheckUrl("https://docs.company.com/"))
+	assert.ErrorIs(t, l.CheckUrl("https://google.com/"), ErrBlocked)
+}
+
+func TestBadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeRules(t, path, "re:(unclosed\n")
+
+	_, err := New(path, false, zap.NewNop())
+	assert.ErrorContains(t, err, "line 1")
+
+	_, err = New(filepath.Join(t.TempDir(), "missing.txt"), false, zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestWatchReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeRules(t, path, "evil.com\n")
+
+	l, err := New(path, false, zap.NewNop())
+	require.NoError(t, err)
+	assert.NoError(t, l.CheckUrl("https://bad.com/"))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go l.Watch(10*time.Millisecond, stop)
+
+	writeRules(t, path, "evil.com\nbad.com\n")
+	// make sure the modification time changes even on coarse filesystems
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+
+	assert.Eventually(t, func() bool {
+		return l.CheckUrl("https://bad.com/") != nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,128 @@
+package bots
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// defaultPatterns match crawlers and the link preview fetchers of common chat
+// and social apps. They are always checked, a patterns file adds to them.
+var defaultPatterns = []string{
+	"bot",
+	"crawler",
+	"spider",
+	"slurp",
+	"facebookexternalhit",
+	"facebookcatalog",
+	"whatsapp",
+	"skypeuripreview",
+	"embedly",
+	"quora link preview",
+	"vkshare",
+	"redditbot",
+	"pinterest",
+	"bitlybot",
+	"google-pagerenderer",
+	"headlesschrome",
+	"python-requests",
+	"go-http-client",
+	"curl/",
+	"wget/",
+}
+
+// prefetch and preview requests are made by browsers and apps before, or
+// instead of, a user following the link
+var prefetchHeaders = map[string][]string{
+	"Purpose":     {"prefetch", "preview"},
+	"Sec-Purpose": {"prefetch", "prerender"},
+	"X-Purpose":   {"prefetch", "preview"},
+	"X-Moz":       {"prefetch"},
+}
+
+// Detector classifies requests as bot traffic. User-agent patterns are read
+// from a local file with one pattern per line:
+//
+//	examplebot        case insensitive substring of the user agent
+//	re:^Mozilla/4\.0$ regular expression matched against the user agent
+//
+// Empty lines and lines starting with # are ignored.
+type Detector struct {
+	substrings []string
+	regexes    []*regexp.Regexp
+}
+
+// New creates a detector with the default patterns and the patterns in path,
+// if path is set.
+func New(path string) (*Detector, error) {
+	d := &Detector{substrings: append([]string{}, defaultPatterns...)}
+	if path == "" {
+		return d, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if expr, ok := strings.CutPrefix(line, "re:"); ok {
+			regex, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("bots.go: line %d: %w", lineNum, err)
+			}
+			d.regexes = append(d.regexes, regex)
+			continue
+		}
+		d.substrings = append(d.substrings, strings.ToLower(line))
+	}
+	return d, scanner.Err()
+}
+
+// IsBot reports whether the request is a HEAD or prefetch request or comes
+// from a user agent matching one of the patterns. Requests without a user
+// agent are treated as bots too.
+func (d *Detector) IsBot(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return true
+	}
+	for header, values := range prefetchHeaders {
+		// e.g. "prefetch;prerender", only the first token is compared
+		value, _, _ := strings.Cut(r.Header.Get(header), ";")
+		value = strings.ToLower(strings.TrimSpace(value))
+		for _, v := range values {
+			if value == v {
+				return true
+			}
+		}
+	}
+
+	ua := r.UserAgent()
+	if ua == "" {
+		return true
+	}
+	lower := strings.ToLower(ua)
+	for _, substring := range d.substrings {
+		if strings.Contains(lower, substring) {
+			return true
+		}
+	}
+	for _, regex := range d.regexes {
+		if regex.MatchString(ua) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package bots
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const firefox = "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0"
+
+func request(method string, ua string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(method, "/abc", nil)
+	if ua != "" {
+		r.Header.Set("User-Agent", ua)
+	}
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func TestIsBot(t *testing.T) {
+	d, err := New("")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		r        *http.Request
+		expected bool
+	}{
+		{"browser", request(http.MethodGet, firefox, nil), false},
+		{"head", request(http.MethodHead, firefox, nil), true},
+		{"chrome prerender", request(http.MethodGet, firefox, map[string]string{"Sec-Purpose": "prefetch;prerender"}), true},
+		{"other purpose", request(http.MethodGet, firefox, map[string]string{"Purpose": "navigate"}), false},
+		{"sec purpose prefetch", request(http.MethodGet, firefox, map[string]string{"Sec-Purpose": "prefetch"}), true},
+		{"purpose prefetch", request(http.MethodGet, firefox, map[string]string{"Purpose": "Prefetch"}), true},
+		{"firefox prefetch", request(http.MethodGet, firefox, map[string]string{"X-Moz": "prefetch"}), true},
+		{"no user agent", request(http.MethodGet, "", nil), true},
+		{"slack", request(http.MethodGet, "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", nil), true},
+		{"facebook", request(http.MethodGet, "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", nil), true},
+		{"whatsapp", request(http.MethodGet, "WhatsApp/2.23.20.0", nil), true},
+		{"googlebot", request(http.MethodGet, "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", nil), true},
+		{"curl", request(http.MethodGet, "curl/8.4.0", nil), true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, d.IsBot(test.r), test.name)
+	}
+}
+
+func TestPatternsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bots.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# internal monitoring\nUptimeChecker\n\nre:^Mozilla/4\\.0$\n"), 0o600))
+
+	d, err := New(path)
+	require.NoError(t, err)
+	assert.True(t, d.IsBot(request(http.MethodGet, "uptimechecker/2.0", nil)))
+	assert.True(t, d.IsBot(request(http.MethodGet, "Mozilla/4.0", nil)))
+	assert.False(t, d.IsBot(request(http.MethodGet, "Mozilla/4.0 (compatible; MSIE 8.0)", nil)))
+	// the default patterns still apply
+	assert.True(t, d.IsBot(request(http.MethodGet, "Twitterbot/1.0", nil)))
+	assert.False(t, d.IsBot(request(http.MethodGet, firefox, nil)))
+
+	require.NoError(t, os.WriteFile(path, []byte("re:(\n"), 0o600))
+	_, err = New(path)
+	assert.Error(t, err)
+
+	_, err = New(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -2,25 +2,49 @@ package main
 
 import (
 	"context"
+	"flag"
+	"os"
 	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
 	"go.uber.org/zap"
 
 	"github.com/moh-osman3/shortener"
+	"github.com/moh-osman3/shortener/config"
 	"github.com/moh-osman3/shortener/managers/def"
+	"github.com/moh-osman3/shortener/tracing"
 )
 
 func main() {
+	configPath := flag.String("config", "", "path to a json config file")
+	flag.Parse()
+
+	if flag.NArg() > 0 && flag.Arg(0) == "trash" {
+		os.Exit(runTrash(flag.Args()[1:]))
+	}
+
 	logger := zap.Must(zap.NewDevelopment())
-	client, err := leveldb.OpenFile(".", nil)
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		logger.Error("unable to load config", zap.Error(err))
+		return
+	}
+
+	ctx := context.Background()
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		logger.Error("unable to set up tracing", zap.Error(err))
+		return
+	}
+	defer shutdownTracing(ctx)
+
+	client, err := leveldb.OpenFile(cfg.DbPath, nil)
 	if err != nil {
 		logger.Error("unable to create leveldb database", zap.Error(err))
 	}
 	// create and start a urlManager
-	urlManager := def.NewDefaultUrlManager(logger, client)
-	ctx := context.Background()
-	err = urlManager.Start(ctx, 10*time.Second, 300*time.Second)
+	urlManager := def.NewDefaultUrlManager(logger, client, cfg)
+	err = urlManager.Start(ctx, time.Duration(cfg.CacheCleanupInterval), time.Duration(cfg.DbCleanupInterval))
 	if err != nil {
 		logger.Error("error starting url manager", zap.Error(err))
 		return
@@ -28,7 +52,7 @@ func main() {
 	defer urlManager.End()
 
 	// create and start server
-	server := shortener.NewServer(urlManager, logger, "3030")
+	server := shortener.NewServer(urlManager, logger, cfg)
 	server.AddDefaultRoutes()
 	err = server.Serve()
 	defer func() {
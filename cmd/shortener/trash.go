@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const trashUsage = `usage: shortener trash [-addr url] [-token token] list
+       shortener trash [-addr url] [-token token] restore <id>`
+
+// runTrash talks to a running server's admin api, since the server holds the
+// lock on the leveldb directory.
+func runTrash(args []string) int {
+	flags := flag.NewFlagSet("trash", flag.ContinueOnError)
+	addr := flags.String("addr", "http://localhost:3030", "address of the running server")
+	token := flags.String("token", os.Getenv("SHORTENER_ADMIN_TOKEN"), "admin token, defaults to $SHORTENER_ADMIN_TOKEN")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var req *http.Request
+	var err error
+	switch {
+	case flags.NArg() == 1 && flags.Arg(0) == "list":
+		req, err = http.NewRequest(http.MethodGet, *addr+"/admin/trash", nil)
+	case flags.NArg() == 2 && flags.Arg(0) == "restore":
+		body, _ := json.Marshal(map[string]string{"id": flags.Arg(1)})
+		req, err = http.NewRequest(http.MethodPost, *addr+"/admin/restore", bytes.NewBuffer(body))
+	default:
+		fmt.Fprintln(os.Stderr, trashUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", resp.Status, strings.TrimSpace(string(out)))
+		return 1
+	}
+	fmt.Println(strings.TrimSpace(string(out)))
+	return 0
+}
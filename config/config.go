@@ -0,0 +1,302 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// Duration wraps time.Duration so it can be written as a string (e.g. "10s"
+// or "30d", see ParseDuration) in the config file.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("config.go: durations must be strings like \"10s\"")
+	}
+	parsed, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// LimitConfig describes a single token bucket. Rate is the number of tokens
+// added per second and Burst is the size of the bucket.
+type LimitConfig struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `json:"enabled"`
+	// limits applied to /create, keyed by api key and by client ip
+	CreatePerKey LimitConfig `json:"create_per_key"`
+	CreatePerIP  LimitConfig `json:"create_per_ip"`
+	// limit applied to redirects, keyed by client ip
+	RedirectPerIP LimitConfig `json:"redirect_per_ip"`
+	// maximum number of buckets kept in memory per limiter
+	MaxKeys int `json:"max_keys"`
+	// use X-Forwarded-For to find the client ip. Only enable behind a trusted proxy.
+	TrustProxyHeaders bool `json:"trust_proxy_headers"`
+	// number of trusted proxies in front of the server that append to
+	// X-Forwarded-For, 1 when 0. Entries left of them are set by the client.
+	TrustedHops int `json:"trusted_hops"`
+}
+
+type URLConfig struct {
+	// scheme added to urls submitted without one
+	DefaultScheme string `json:"default_scheme"`
+	// schemes that may be shortened, defaults to http and https
+	AllowedSchemes []string `json:"allowed_schemes"`
+	MaxLength      int      `json:"max_length"`
+}
+
+type BlocklistConfig struct {
+	// path to the rules file, the blocklist is disabled when empty
+	Path           string   `json:"path"`
+	ReloadInterval Duration `json:"reload_interval"`
+	// only allow destinations that match an allow rule
+	RequireAllow bool `json:"require_allow"`
+}
+
+type TracingConfig struct {
+	// "otlp", "stdout" or empty to turn tracing off
+	Exporter string `json:"exporter"`
+	// otlp http endpoint, defaults to localhost:4318
+	Endpoint string `json:"endpoint"`
+	Insecure bool   `json:"insecure"`
+	// fraction of new traces to sample, defaults to all of them
+	SampleRatio float64 `json:"sample_ratio"`
+}
+
+type ClicksConfig struct {
+	// size of the in memory queue of clicks waiting to be written
+	QueueSize int `json:"queue_size"`
+	// how often queued clicks are written to the db
+	FlushInterval Duration `json:"flush_interval"`
+	// flush early once this many clicks are pending
+	BatchSize int `json:"batch_size"`
+	// what to do when the queue is full: "drop" the click or "block" the redirect
+	Backpressure string `json:"backpressure"`
+}
+
+type StatsConfig struct {
+	// how long hourly click buckets are kept, defaults to 30 days
+	HourlyRetention Duration `json:"hourly_retention"`
+	// how long daily click buckets and breakdowns are kept, forever when 0
+	DailyRetention Duration `json:"daily_retention"`
+}
+
+type GeoConfig struct {
+	// path to a MaxMind format (mmdb) city or country database, clicks are
+	// not geolocated when empty
+	DatabasePath string `json:"database_path"`
+}
+
+type BotsConfig struct {
+	// count crawlers, link previews and prefetches separately from people
+	Enabled bool `json:"enabled"`
+	// file with extra user agent patterns on top of the built in ones
+	PatternsPath string `json:"patterns_path"`
+}
+
+type WebhooksConfig struct {
+	// deliveries are dead lettered after this many failed attempts
+	MaxAttempts int `json:"max_attempts"`
+	// wait before the first retry, doubled after every failed attempt up to
+	// MaxBackoff
+	InitialBackoff Duration `json:"initial_backoff"`
+	MaxBackoff     Duration `json:"max_backoff"`
+	// timeout of a single delivery request
+	Timeout Duration `json:"timeout"`
+	// how often the delivery queue is checked for retries that are due
+	PollInterval Duration `json:"poll_interval"`
+	// how long the delivery log is kept
+	LogRetention Duration `json:"log_retention"`
+}
+
+type ExpiryConfig struct {
+	// expiry of short urls created without one, defaults to a year
+	Default Duration `json:"default"`
+	// latest expiry a short url can be created with, unlimited when 0
+	Max Duration `json:"max"`
+	// idle ttl of short urls created without one, off when 0
+	IdleTTL Duration `json:"idle_ttl"`
+	// how long expired short urls answer 410 Gone and can be renewed before
+	// they are purged, defaults to 30 days
+	GracePeriod Duration `json:"grace_period"`
+}
+
+type ActivationConfig struct {
+	// html/template file served before a short url's active_from, a built in
+	// page is used when empty. The template gets .Id and .ActiveFrom.
+	PagePath string `json:"page_path"`
+}
+
+type FallbackConfig struct {
+	// fallback urls keyed by the domain short urls are requested on, "*"
+	// applies to all other domains. They are used when a short url expired,
+	// was disabled, used up its clicks or doesn't exist and has no fallback
+	// url of its own.
+	Urls map[string]string `json:"urls"`
+	// html/template files served for short urls that don't exist (404) and
+	// ones that expired, were disabled or used up their clicks (410), built in
+	// pages are used when empty. The templates get .Title, .Message and
+	// .Reason.
+	NotFoundPagePath string `json:"not_found_page_path"`
+	GonePagePath     string `json:"gone_page_path"`
+}
+
+type PasswordsConfig struct {
+	// how long a correct password unlocks a short url in the browser,
+	// defaults to an hour
+	CookieTTL Duration `json:"cookie_ttl"`
+	// password attempts allowed per short url and window, defaults to 5 per
+	// 15 minutes
+	MaxAttempts int      `json:"max_attempts"`
+	Window      Duration `json:"window"`
+}
+
+type SigningKey struct {
+	// sent along with the signature so the right key is used to check it
+	Id     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+type SigningConfig struct {
+	// keys of the access tokens of signed short urls. The first key signs new
+	// tokens and all of them are accepted, so keys are rotated by adding a
+	// new key in front and removing the old one once its tokens expired.
+	Keys []SigningKey `json:"keys"`
+}
+
+type StreamConfig struct {
+	// events buffered per stream subscriber, subscribers that fall further
+	// behind are disconnected
+	BufferSize int `json:"buffer_size"`
+	// maximum number of open event streams
+	MaxSubscribers int `json:"max_subscribers"`
+	// how often a comment is sent on idle streams to keep proxies from
+	// closing them
+	KeepAlive Duration `json:"keep_alive"`
+}
+
+type Config struct {
+	Port                 string           `json:"port"`
+	DbPath               string           `json:"db_path"`
+	CacheCleanupInterval Duration         `json:"cache_cleanup_interval"`
+	DbCleanupInterval    Duration         `json:"db_cleanup_interval"`
+	RateLimit            RateLimitConfig  `json:"rate_limit"`
+	URL                  URLConfig        `json:"url"`
+	Blocklist            BlocklistConfig  `json:"blocklist"`
+	Tracing              TracingConfig    `json:"tracing"`
+	Clicks               ClicksConfig     `json:"clicks"`
+	Stats                StatsConfig      `json:"stats"`
+	Geo                  GeoConfig        `json:"geo"`
+	Bots                 BotsConfig       `json:"bots"`
+	Webhooks             WebhooksConfig   `json:"webhooks"`
+	Stream               StreamConfig     `json:"stream"`
+	Expiry               ExpiryConfig     `json:"expiry"`
+	Activation           ActivationConfig `json:"activation"`
+	Fallback             FallbackConfig   `json:"fallback"`
+	Passwords            PasswordsConfig  `json:"passwords"`
+	Signing              SigningConfig    `json:"signing"`
+	// port serving prometheus metrics at /metrics, disabled when empty
+	MetricsPort string `json:"metrics_port"`
+	// domains the shortener is served from. Urls pointing back at them are
+	// rejected to avoid redirect loops.
+	OwnDomains []string `json:"own_domains"`
+	// bearer token for the /admin endpoints, which are disabled when empty
+	AdminToken string `json:"admin_token"`
+	// how long deleted short urls can be restored before they are purged
+	TrashRetention Duration `json:"trash_retention"`
+}
+
+func Default() *Config {
+	return &Config{
+		Port:                 "3030",
+		MetricsPort:          "8888",
+		DbPath:               ".",
+		CacheCleanupInterval: Duration(10 * time.Second),
+		DbCleanupInterval:    Duration(300 * time.Second),
+		RateLimit: RateLimitConfig{
+			Enabled:       true,
+			CreatePerKey:  LimitConfig{Rate: 1, Burst: 20},
+			CreatePerIP:   LimitConfig{Rate: 1, Burst: 20},
+			RedirectPerIP: LimitConfig{Rate: 50, Burst: 200},
+			MaxKeys:       10000,
+			TrustedHops:   1,
+		},
+		URL: URLConfig{
+			DefaultScheme:  "https",
+			AllowedSchemes: []string{"http", "https"},
+			MaxLength:      2048,
+		},
+		Clicks: ClicksConfig{
+			QueueSize:     10000,
+			FlushInterval: Duration(time.Second),
+			BatchSize:     1000,
+			Backpressure:  "drop",
+		},
+		Blocklist: BlocklistConfig{
+			ReloadInterval: Duration(30 * time.Second),
+		},
+		Stats: StatsConfig{
+			HourlyRetention: Duration(30 * 24 * time.Hour),
+		},
+		Bots: BotsConfig{
+			Enabled: true,
+		},
+		Webhooks: WebhooksConfig{
+			MaxAttempts:    8,
+			InitialBackoff: Duration(10 * time.Second),
+			MaxBackoff:     Duration(time.Hour),
+			Timeout:        Duration(10 * time.Second),
+			PollInterval:   Duration(time.Second),
+			LogRetention:   Duration(7 * 24 * time.Hour),
+		},
+		Expiry: ExpiryConfig{
+			Default:     Duration(365 * 24 * time.Hour),
+			GracePeriod: Duration(30 * 24 * time.Hour),
+		},
+		Passwords: PasswordsConfig{
+			CookieTTL:   Duration(time.Hour),
+			MaxAttempts: 5,
+			Window:      Duration(15 * time.Minute),
+		},
+		Stream: StreamConfig{
+			BufferSize:     64,
+			MaxSubscribers: 1000,
+			KeepAlive:      Duration(15 * time.Second),
+		},
+		OwnDomains:     []string{"localhost"},
+		TrashRetention: Duration(30 * 24 * time.Hour),
+	}
+}
+
+// Load reads a json config file on top of the defaults, so a config file only
+// needs to set the fields it wants to change.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
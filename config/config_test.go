@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+func TestLoadOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"port":"8080","db_cleanup_interval":"1m","expiry":{"default":"30d","max":"1y"},"rate_limit":{"create_per_ip":{"rate":2,"burst":5}}}`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "8080", cfg.Port)
+	assert.Equal(t, Duration(time.Minute), cfg.DbCleanupInterval)
+	assert.Equal(t, LimitConfig{Rate: 2, Burst: 5}, cfg.RateLimit.CreatePerIP)
+	assert.Equal(t, Duration(30*24*time.Hour), cfg.Expiry.Default)
+	assert.Equal(t, Duration(365*24*time.Hour), cfg.Expiry.Max)
+	// untouched fields keep their defaults
+	assert.Equal(t, Default().CacheCleanupInterval, cfg.CacheCleanupInterval)
+	assert.Equal(t, Default().RateLimit.RedirectPerIP, cfg.RateLimit.RedirectPerIP)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"db_cleanup_interval":"soon"}`), 0o600))
+	_, err = Load(path)
+	assert.Error(t, err)
+}
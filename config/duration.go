@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+	// years are 365 days, leap days are not taken into account
+	year = 365 * day
+)
+
+var longUnits = map[string]time.Duration{"d": day, "w": week, "y": year}
+
+// ParseDuration extends time.ParseDuration with the units d (24h), w (7d) and
+// y (365d), e.g. "10d", "1w2d" or "1y12h". Durations that don't fit in a
+// time.Duration are rejected.
+func ParseDuration(value string) (time.Duration, error) {
+	invalid := fmt.Errorf("duration.go: invalid duration %q", value)
+	s := value
+	if s == "" {
+		return 0, invalid
+	}
+	sign := time.Duration(1)
+	if s[0] == '-' || s[0] == '+' {
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = s[1:]
+	}
+
+	// long units are summed here, everything else is left to time.ParseDuration
+	var total time.Duration
+	var rest strings.Builder
+	for s != "" {
+		i := 0
+		for i < len(s) && (s[i] == '.' || (s[i] >= '0' && s[i] <= '9')) {
+			i++
+		}
+		if i == 0 {
+			return 0, invalid
+		}
+		number := s[:i]
+		j := i
+		for j < len(s) && s[j] != '.' && (s[j] < '0' || s[j] > '9') {
+			j++
+		}
+		unit := s[i:j]
+		s = s[j:]
+
+		multiplier, ok := longUnits[unit]
+		if !ok {
+			rest.WriteString(number + unit)
+			continue
+		}
+		n, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return 0, invalid
+		}
+		// float64(math.MaxInt64) rounds up to 2^63, which is already too big
+		d := n * float64(multiplier)
+		if d >= float64(math.MaxInt64) || time.Duration(d) > math.MaxInt64-total {
+			return 0, invalid
+		}
+		total += time.Duration(d)
+	}
+	if rest.Len() > 0 {
+		d, err := time.ParseDuration(rest.String())
+		if err != nil || d > math.MaxInt64-total {
+			return 0, invalid
+		}
+		total += d
+	}
+	return sign * total, nil
+}
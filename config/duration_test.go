@@ -0,0 +1,37 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"10s", 10 * time.Second},
+		{"1h30m", 90 * time.Minute},
+		{"10d", 10 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"1y", 365 * 24 * time.Hour},
+		{"1w2d", 9 * 24 * time.Hour},
+		{"1d12h", 36 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+		{"-1d", -24 * time.Hour},
+		{"500ms", 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.want, got, tt.in)
+	}
+
+	// durations past math.MaxInt64 nanoseconds (about 292 years) overflow
+	for _, in := range []string{"", "d", "10", "10x", "1d10", "wrongtype", "1..5d", "1e10y", "300y", "292y5000h", "200y100y"} {
+		_, err := ParseDuration(in)
+		assert.Error(t, err, in)
+	}
+}
@@ -0,0 +1,58 @@
+package geo
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location is the country and region of an ip address. Country is the ISO
+// 3166-1 code (e.g. "US") and region the ISO 3166-2 code of the largest
+// subdivision (e.g. "US-CA").
+type Location struct {
+	Country string
+	Region  string
+}
+
+// record holds the fields read from GeoIP2/GeoLite2 City and Country
+// databases. Country databases have no subdivisions.
+type record struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+}
+
+// Reader looks up ip addresses in a local MaxMind format (mmdb) database.
+type Reader struct {
+	db *maxminddb.Reader
+}
+
+func Open(path string) (*Reader, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{db: db}, nil
+}
+
+// Lookup returns the location of ip, or an empty location if the ip is not
+// in the database.
+func (r *Reader) Lookup(ip net.IP) (Location, error) {
+	var rec record
+	if err := r.db.Lookup(ip, &rec); err != nil {
+		return Location{}, err
+	}
+
+	loc := Location{Country: rec.Country.IsoCode}
+	if loc.Country != "" && len(rec.Subdivisions) > 0 && rec.Subdivisions[0].IsoCode != "" {
+		loc.Region = loc.Country + "-" + rec.Subdivisions[0].IsoCode
+	}
+	return loc, nil
+}
+
+func (r *Reader) Close() error {
+	return r.db.Close()
+}
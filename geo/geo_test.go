@@ -0,0 +1,45 @@
+package geo_test
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moh-osman3/shortener/geo"
+	"github.com/moh-osman3/shortener/geo/geotest"
+)
+
+func TestLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geo.mmdb")
+	require.NoError(t, geotest.WriteDatabase(path, map[string]geo.Location{
+		"81.2.69.0/24":   {Country: "GB", Region: "GB-ENG"},
+		"2001:480::/32":  {Country: "US", Region: "US-CA"},
+		"202.196.0.0/16": {Country: "PH"},
+	}))
+
+	reader, err := geo.Open(path)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	tests := []struct {
+		ip       string
+		expected geo.Location
+	}{
+		{"81.2.69.160", geo.Location{Country: "GB", Region: "GB-ENG"}},
+		{"2001:480::1", geo.Location{Country: "US", Region: "US-CA"}},
+		// country only databases have no region
+		{"202.196.224.5", geo.Location{Country: "PH"}},
+		{"1.1.1.1", geo.Location{}},
+	}
+	for _, test := range tests {
+		loc, err := reader.Lookup(net.ParseIP(test.ip))
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, loc, test.ip)
+	}
+
+	_, err = geo.Open(filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+// Package geotest writes small mmdb databases for tests.
+package geotest
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+
+	"github.com/moh-osman3/shortener/geo"
+)
+
+// WriteDatabase writes a GeoIP2-City style database to path that maps each
+// network in cidr notation to its location.
+func WriteDatabase(path string, networks map[string]geo.Location) error {
+	writer, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType:            "GeoIP2-City",
+		IncludeReservedNetworks: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	for cidr, loc := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		data := mmdbtype.Map{
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String(loc.Country)},
+		}
+		if _, subdivision, ok := strings.Cut(loc.Region, "-"); ok {
+			data["subdivisions"] = mmdbtype.Slice{
+				mmdbtype.Map{"iso_code": mmdbtype.String(subdivision)},
+			}
+		}
+		if err := writer.Insert(network, data); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = writer.WriteTo(f)
+	return err
+}
@@ -0,0 +1,69 @@
+package def
+
+import (
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/urls"
+)
+
+var pendingTemplate = template.Must(template.New("pending").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Not available yet</title></head>
+<body>
+<h1>Not available yet</h1>
+<p>This short url becomes available on {{.ActiveFrom}}.</p>
+</body>
+</html>
+`))
+
+// loadPendingTemplate replaces the built in not yet available page with the
+// configured one.
+func (m *defaultUrlManager) loadPendingTemplate() error {
+	if m.config.Activation.PagePath == "" {
+		return nil
+	}
+	tmpl, err := template.ParseFiles(m.config.Activation.PagePath)
+	if err != nil {
+		return err
+	}
+	m.pendingTemplate = tmpl
+	return nil
+}
+
+// servePending answers requests for a short url before its active_from, with
+// a redirect to its prelaunch url or the not yet available page. Neither is
+// cached, so the short url redirects to its long url as soon as it is active.
+func (m *defaultUrlManager) servePending(w http.ResponseWriter, r *http.Request, shortUrl urls.ShortUrl) {
+	w.Header().Set("Cache-Control", "no-store")
+	if prelaunch := shortUrl.GetOptions().PrelaunchUrl; prelaunch != "" {
+		http.Redirect(w, r, prelaunch, http.StatusFound)
+		return
+	}
+
+	activeFrom := shortUrl.GetActiveFrom()
+	retryAfter := math.Ceil(time.Until(activeFrom).Seconds())
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(retryAfter, 1))))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	tmpl := m.pendingTemplate
+	if tmpl == nil {
+		tmpl = pendingTemplate
+	}
+	err := tmpl.Execute(w, struct {
+		Id         string
+		ActiveFrom string
+	}{
+		Id:         shortUrl.GetId(),
+		ActiveFrom: activeFrom.UTC().Format(time.RFC1123),
+	})
+	if err != nil {
+		m.logger.Error("activation.go: failed to render not yet available page", zap.Error(err))
+	}
+}
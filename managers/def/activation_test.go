@@ -0,0 +1,102 @@
+package def
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moh-osman3/shortener/urls"
+)
+
+func resolve(m *defaultUrlManager, id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+	w := httptest.NewRecorder()
+	m.GetUrlHandleFunc(w, req)
+	return w
+}
+
+func TestScheduledActivation(t *testing.T) {
+	m := newIdleTestManager()
+	activeFrom := time.Now().Add(200 * time.Millisecond)
+	shortUrl, err := m.createShortUrlWithOptions("https://example.com/launch", time.Time{}, activeFrom, urls.Options{})
+	require.NoError(t, err)
+
+	w := resolve(m, shortUrl.GetId())
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "Not available yet")
+	assert.Contains(t, w.Body.String(), activeFrom.UTC().Format(time.RFC1123))
+
+	// early requests are not counted as clicks
+	assert.Equal(t, int64(0), m.getCounts(shortUrl).Total)
+
+	// it starts redirecting on its own
+	time.Sleep(time.Until(activeFrom))
+	w = resolve(m, shortUrl.GetId())
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/launch", w.Header().Get("Location"))
+}
+
+func TestPrelaunchUrl(t *testing.T) {
+	m := newIdleTestManager()
+	activeFrom := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/launch","active_from":"`+activeFrom+`","prelaunch_url":"example.com/teaser"}`)
+
+	w := resolve(m, id)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/teaser", w.Header().Get("Location"))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	// the summary is there before launch
+	req := httptest.NewRequest(http.MethodGet, "/"+id+"/summary", nil)
+	w = httptest.NewRecorder()
+	m.GetUrlHandleFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPendingPageFromConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pending.html")
+	require.NoError(t, os.WriteFile(path, []byte(`<p>{{.Id}} launches {{.ActiveFrom}}</p>`), 0o644))
+
+	m := newIdleTestManager()
+	m.config.Activation.PagePath = path
+	require.NoError(t, m.loadPendingTemplate())
+
+	shortUrl, err := m.createShortUrlWithOptions("https://example.com/launch", time.Time{}, time.Now().Add(time.Hour), urls.Options{})
+	require.NoError(t, err)
+	w := resolve(m, shortUrl.GetId())
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "<p>"+shortUrl.GetId()+" launches ")
+
+	m.config.Activation.PagePath = filepath.Join(t.TempDir(), "missing.html")
+	assert.Error(t, m.loadPendingTemplate())
+}
+
+func TestCreateUrlActiveFrom(t *testing.T) {
+	m := newIdleTestManager()
+	activeFrom := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	id := createLimitedUrl(t, m, `{"url":"https://example.com","active_from":"`+activeFrom.Format(time.RFC3339)+`"}`)
+	m.lock.RLock()
+	assert.True(t, activeFrom.Equal(m.lookupShortUrl(id).GetActiveFrom()))
+	m.lock.RUnlock()
+
+	for _, bad := range []string{
+		`{"url":"https://example.com","active_from":"tomorrow"}`,
+		`{"url":"https://example.com","expiry":"1h","active_from":"` + time.Now().Add(2*time.Hour).UTC().Format(time.RFC3339) + `"}`,
+		`{"url":"https://example.com","prelaunch_url":"https://example.com/teaser"}`,
+	} {
+		req, err := http.NewRequest(http.MethodPost, "/create", strings.NewReader(bad))
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		m.CreateUrlHandleFunc(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, bad)
+	}
+}
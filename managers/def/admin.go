@@ -0,0 +1,188 @@
+package def
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/urls"
+	"github.com/moh-osman3/shortener/webhooks"
+)
+
+type disableData struct {
+	Id     string `json:"id"`
+	Reason string `json:"reason"`
+	Legal  bool   `json:"legal"`
+}
+
+type adminUrlData struct {
+	ShortUrl json.RawMessage `json:"short_url"`
+	Summary  string          `json:"summary"`
+}
+
+// isAdmin checks the bearer token against the configured admin token. Admin
+// endpoints are turned off when no token is configured.
+func (m *defaultUrlManager) isAdmin(r *http.Request) bool {
+	if m.config.AdminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(m.config.AdminToken)) == 1
+}
+
+// setDisabled updates the disabled state of a short url in both the cache and
+// the db. Pass nil to enable the short url again.
+func (m *defaultUrlManager) setDisabled(id string, state *urls.DisabledState) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	existing := m.lookupShortUrl(id)
+	if existing == nil {
+		return errors.New("admin.go: short url does not exist")
+	}
+	shortUrl, err := copyShortUrl(existing)
+	if err != nil {
+		return err
+	}
+	shortUrl.SetDisabled(state)
+
+	shortUrlStr, err := shortUrl.Marshal()
+	if err != nil {
+		return err
+	}
+	err = m.leveldb.Put([]byte(id), shortUrlStr, nil)
+	if err != nil {
+		return err
+	}
+	m.cache[id] = shortUrl
+
+	if state != nil {
+		m.publish(webhooks.EventDisabled, shortUrl)
+	} else {
+		m.publish(webhooks.EventUpdated, shortUrl)
+	}
+	return nil
+}
+
+func (m *defaultUrlManager) serveTombstone(w http.ResponseWriter, state *urls.DisabledState) {
+	code := http.StatusGone
+	if state.Legal {
+		code = http.StatusUnavailableForLegalReasons
+	}
+
+	m.serveErrorPage(w, code, "This short url has been disabled and no longer redirects.", state.Reason)
+}
+
+func (m *defaultUrlManager) DisableUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method: expected POST request", http.StatusMethodNotAllowed)
+		return
+	}
+	if !m.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	var disableData disableData
+	json.Unmarshal(body, &disableData)
+	if !isShortUrlId(disableData.Id) {
+		http.Error(w, "invalid short url id", http.StatusBadRequest)
+		return
+	}
+
+	state := &urls.DisabledState{
+		Reason:    disableData.Reason,
+		Legal:     disableData.Legal,
+		Timestamp: time.Now(),
+	}
+	err = m.setDisabled(disableData.Id, state)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	m.logger.Info("admin.go: disabled short url", zap.String("id", disableData.Id), zap.String("reason", disableData.Reason))
+	io.WriteString(w, "Successfully disabled short url!")
+}
+
+func (m *defaultUrlManager) EnableUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method: expected POST request", http.StatusMethodNotAllowed)
+		return
+	}
+	if !m.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	var enableData deleteData
+	json.Unmarshal(body, &enableData)
+	if !isShortUrlId(enableData.Id) {
+		http.Error(w, "invalid short url id", http.StatusBadRequest)
+		return
+	}
+
+	err = m.setDisabled(enableData.Id, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	m.logger.Info("admin.go: enabled short url", zap.String("id", enableData.Id))
+	io.WriteString(w, "Successfully enabled short url!")
+}
+
+// AdminGetUrlHandleFunc returns the full record and summary of a short url,
+// including disabled ones, at /admin/urls/<id>.
+func (m *defaultUrlManager) AdminGetUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method: expected GET request", http.StatusMethodNotAllowed)
+		return
+	}
+	if !m.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/admin/urls/")
+	if !isShortUrlId(id) {
+		http.Error(w, "invalid short url id", http.StatusBadRequest)
+		return
+	}
+	m.lock.RLock()
+	shortUrl := m.lookupShortUrl(id)
+	m.lock.RUnlock()
+	if shortUrl == nil {
+		http.Error(w, "short url does not exist", http.StatusNotFound)
+		return
+	}
+
+	record, err := shortUrl.Marshal()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(adminUrlData{
+		ShortUrl: record,
+		Summary:  m.getSummary(shortUrl),
+	})
+}
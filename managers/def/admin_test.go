@@ -0,0 +1,185 @@
+package def
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cyrildever/feistel"
+	"github.com/cyrildever/feistel/common/utils/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/config"
+	"github.com/moh-osman3/shortener/stats"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+const testAdminToken = "test-admin-token"
+
+func newAdminRequest(t *testing.T, method string, path string, body string) *http.Request {
+	req, err := http.NewRequest(method, path, bytes.NewBuffer([]byte(body)))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	return req
+}
+
+func TestDisableAndEnableUrl(t *testing.T) {
+	m := &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+		config:  config.Config{AdminToken: testAdminToken},
+	}
+	createdSurl, err := m.createShortUrl("www.testlongurl.com", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	id := createdSurl.GetId()
+	m.recordClick(context.Background(), createdSurl, stats.Click{Time: time.Now()})
+
+	disable := http.HandlerFunc(m.DisableUrlHandleFunc)
+	enable := http.HandlerFunc(m.EnableUrlHandleFunc)
+	get := http.HandlerFunc(m.GetUrlHandleFunc)
+	adminGet := http.HandlerFunc(m.AdminGetUrlHandleFunc)
+
+	// test missing or wrong admin token
+	body := fmt.Sprintf("{\"id\":\"%s\",\"reason\":\"phishing\"}", id)
+	req := newAdminRequest(t, http.MethodPost, "/admin/disable", body)
+	req.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+	disable.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// test disabling a short url that does not exist
+	w = httptest.NewRecorder()
+	disable.ServeHTTP(w, newAdminRequest(t, http.MethodPost, "/admin/disable", "{\"id\":\"missing\"}"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// test happy path disable
+	w = httptest.NewRecorder()
+	disable.ServeHTTP(w, newAdminRequest(t, http.MethodPost, "/admin/disable", body))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// redirect and summary serve the tombstone page
+	for _, path := range []string{"/" + id, "/" + id + "/summary"} {
+		w = httptest.NewRecorder()
+		get.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusGone, w.Code)
+		assert.Contains(t, w.Body.String(), "phishing")
+	}
+
+	// the record is persisted to the db
+	val, err := m.leveldb.Get([]byte(id), nil)
+	require.NoError(t, err)
+	stored := urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
+	stored.Unmarshal(val)
+	require.NotNil(t, stored.GetDisabled())
+	assert.Equal(t, "phishing", stored.GetDisabled().Reason)
+
+	// admins can still see the record and stats
+	w = httptest.NewRecorder()
+	adminGet.ServeHTTP(w, newAdminRequest(t, http.MethodGet, "/admin/urls/"+id, ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var data adminUrlData
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+	assert.Equal(t, m.getSummary(createdSurl), data.Summary)
+	assert.Contains(t, data.Summary, "total calls since creation: 1 calls")
+	assert.Contains(t, string(data.ShortUrl), "phishing")
+
+	// disabled short urls can't be deleted
+	w = httptest.NewRecorder()
+	deleteReq := httptest.NewRequest(http.MethodDelete, "/delete", bytes.NewBuffer([]byte(body)))
+	http.HandlerFunc(m.DeleteUrlHandleFunc).ServeHTTP(w, deleteReq)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// and the id is never reissued, even for the same long url
+	m.numUrls = 0
+	newSurl, err := m.createShortUrl("www.testlongurl.com", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	assert.NotEqual(t, id, newSurl.GetId())
+
+	// legal takedowns use 451
+	body = fmt.Sprintf("{\"id\":\"%s\",\"reason\":\"court order\",\"legal\":true}", id)
+	w = httptest.NewRecorder()
+	disable.ServeHTTP(w, newAdminRequest(t, http.MethodPost, "/admin/disable", body))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	get.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, w.Code)
+
+	// enabling restores redirects
+	w = httptest.NewRecorder()
+	enable.ServeHTTP(w, newAdminRequest(t, http.MethodPost, "/admin/enable", fmt.Sprintf("{\"id\":\"%s\"}", id)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	get.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+}
+
+func TestAdminDisabledWithoutToken(t *testing.T) {
+	m := &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+	}
+
+	w := httptest.NewRecorder()
+	http.HandlerFunc(m.AdminGetUrlHandleFunc).ServeHTTP(w, newAdminRequest(t, http.MethodGet, "/admin/urls/id", ""))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestExpiredDisabledUrlIsKept(t *testing.T) {
+	m := &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+	}
+	createdSurl, err := m.createShortUrl("www.testlongurl.com", time.Now().Add(time.Millisecond))
+	require.NoError(t, err)
+	require.NoError(t, m.setDisabled(createdSurl.GetId(), &urls.DisabledState{Reason: "spam"}))
+
+	time.Sleep(5 * time.Millisecond)
+	m.scanAndDeleteCache()
+
+	_, ok := m.cache[createdSurl.GetId()]
+	assert.True(t, ok)
+	_, err = m.leveldb.Get([]byte(createdSurl.GetId()), nil)
+	assert.NoError(t, err)
+}
+
+func TestSetDisabledReplacesCachedUrl(t *testing.T) {
+	m := newIdleTestManager()
+	createdSurl, err := m.createShortUrl("https://example.com/", time.Time{})
+	require.NoError(t, err)
+	id := createdSurl.GetId()
+
+	// requests resolving the short url while it is disabled and enabled keep
+	// reading the record they looked up
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			resolve(m, id)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, m.setDisabled(id, &urls.DisabledState{Reason: "spam"}))
+		require.NoError(t, m.setDisabled(id, nil))
+	}
+	<-done
+
+	require.NoError(t, m.setDisabled(id, &urls.DisabledState{Reason: "spam"}))
+	assert.Nil(t, createdSurl.GetDisabled())
+	m.lock.RLock()
+	assert.Equal(t, "spam", m.lookupShortUrl(id).GetDisabled().Reason)
+	m.lock.RUnlock()
+}
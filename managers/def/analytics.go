@@ -0,0 +1,210 @@
+package def
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/ratelimit"
+	"github.com/moh-osman3/shortener/stats"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+const (
+	defaultStatsWindow          = 7 * 24 * time.Hour
+	defaultStatsHourlyRetention = 30 * 24 * time.Hour
+
+	// salt of the visitor hashes, generated on first start. Changing it makes
+	// returning visitors count as new ones.
+	visitorSaltKey    = "meta:visitor_salt"
+	visitorSaltLength = 32
+)
+
+// parseStatsTime accepts RFC 3339 timestamps and plain dates, which are read
+// as midnight in loc.
+func parseStatsTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("analytics.go: invalid time %q, expected RFC 3339 or YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+// loadVisitorSalt reads the visitor hash salt from the db, creating it the
+// first time the manager starts.
+func (m *defaultUrlManager) loadVisitorSalt() error {
+	salt, err := m.leveldb.Get([]byte(visitorSaltKey), nil)
+	if err == nil {
+		m.visitorSalt = salt
+		return nil
+	}
+	if !errors.Is(err, leveldb.ErrNotFound) {
+		return err
+	}
+
+	salt = make([]byte, visitorSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	if err := m.leveldb.Put([]byte(visitorSaltKey), salt, nil); err != nil {
+		return err
+	}
+	m.visitorSalt = salt
+	return nil
+}
+
+// newClick reads the dimensions of a redirect, identifies the visitor and
+// geolocates the client when a geo database is configured. The client ip is
+// only used for the hash and the lookup and is never stored. Bot clicks are
+// only counted, so they skip the rest.
+func (m *defaultUrlManager) newClick(r *http.Request) stats.Click {
+	click := stats.NewClick(r, time.Now())
+	if m.bots != nil && m.bots.IsBot(r) {
+		click.Bot = true
+		return click
+	}
+
+	// the same client ip the per ip rate limits are keyed by
+	ip := net.ParseIP(ratelimit.ClientIP(r, m.config.RateLimit))
+	click.Visitor = stats.VisitorHash(m.visitorSalt, ip, r.UserAgent())
+	if m.geo == nil {
+		return click
+	}
+
+	click.Country, click.Region = stats.Unknown, stats.Unknown
+	if ip == nil {
+		return click
+	}
+	loc, err := m.geo.Lookup(ip)
+	if err != nil {
+		m.logger.Debug("analytics.go: geo lookup failed", zap.Error(err))
+		return click
+	}
+	if loc.Country != "" {
+		click.Country = loc.Country
+	}
+	if loc.Region != "" {
+		click.Region = loc.Region
+	}
+	return click
+}
+
+func (m *defaultUrlManager) getReport(shortUrl urls.ShortUrl, opts stats.Options) (stats.Report, error) {
+	// the hour bucket containing the cutoff is kept until it is out of the
+	// window as a whole
+	opts.HourlyFrom = time.Now().Add(-m.hourlyRetention()).Truncate(time.Hour)
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return stats.Query(m.leveldb, shortUrl.GetId(), opts)
+}
+
+// parseWindow parses the length of a stats window in the format of expiries,
+// e.g. "30d", "2w" or "12h".
+func parseWindow(value string) (time.Duration, error) {
+	window, err := urls.ParseDuration(value)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("analytics.go: invalid window %q, expected e.g. \"30d\" or \"12h\"", value)
+	}
+	return window, nil
+}
+
+// statsOptions reads the report options from the query parameters:
+//
+//	from, to     RFC 3339 timestamps or dates (midnight in tz), to defaults to now
+//	window       length of the range ending at to when from is not set, e.g. "90d"
+//	granularity  hour, day (default), week or month
+//	tz           IANA timezone the buckets are aligned to, defaults to UTC
+func statsOptions(query url.Values) (stats.Options, error) {
+	opts := stats.Options{Granularity: query.Get("granularity"), Location: time.UTC}
+	if tz := query.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return opts, fmt.Errorf("analytics.go: unknown timezone %q", tz)
+		}
+		opts.Location = loc
+	}
+
+	opts.To = time.Now().In(opts.Location)
+	if value := query.Get("to"); value != "" {
+		parsed, err := parseStatsTime(value, opts.Location)
+		if err != nil {
+			return opts, err
+		}
+		opts.To = parsed
+	}
+
+	window := defaultStatsWindow
+	if value := query.Get("window"); value != "" {
+		if query.Get("from") != "" {
+			return opts, errors.New("analytics.go: set either from or window")
+		}
+		parsed, err := parseWindow(value)
+		if err != nil {
+			return opts, err
+		}
+		window = parsed
+	}
+	opts.From = opts.To.Add(-window)
+	if value := query.Get("from"); value != "" {
+		parsed, err := parseStatsTime(value, opts.Location)
+		if err != nil {
+			return opts, err
+		}
+		opts.From = parsed
+	}
+	return opts, opts.Validate()
+}
+
+// serveStats writes the click report of a short url as json, see statsOptions
+// for the query parameters.
+func (m *defaultUrlManager) serveStats(w http.ResponseWriter, r *http.Request, shortUrl urls.ShortUrl) {
+	opts, err := statsOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := m.getReport(shortUrl, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (m *defaultUrlManager) hourlyRetention() time.Duration {
+	if m.config.Stats.HourlyRetention <= 0 {
+		return defaultStatsHourlyRetention
+	}
+	return time.Duration(m.config.Stats.HourlyRetention)
+}
+
+// pruneStats drops click buckets that are older than their retention period.
+// It doesn't take the lock: the buckets it deletes are no longer written to,
+// and the iterator reads a snapshot, so redirects and creates aren't held up
+// for the length of the scan.
+func (m *defaultUrlManager) pruneStats() {
+	hourlyRetention := m.hourlyRetention()
+	dailyRetention := time.Duration(m.config.Stats.DailyRetention)
+
+	batch := new(leveldb.Batch)
+	pruned, err := stats.Prune(m.leveldb, batch, time.Now(), hourlyRetention, dailyRetention)
+	if err == nil && pruned > 0 {
+		err = m.leveldb.Write(batch, nil)
+	}
+	if err != nil {
+		m.logger.Error("analytics.go: failed to prune stats", zap.Error(err))
+	}
+}
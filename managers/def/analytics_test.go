@@ -0,0 +1,332 @@
+package def
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cyrildever/feistel"
+	"github.com/cyrildever/feistel/common/utils/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/config"
+	"github.com/moh-osman3/shortener/geo"
+	"github.com/moh-osman3/shortener/geo/geotest"
+	"github.com/moh-osman3/shortener/stats"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+func TestStatsEndpoint(t *testing.T) {
+	m := &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+	}
+	handler := http.HandlerFunc(m.GetUrlHandleFunc)
+
+	createdSurl, err := m.createShortUrl("https://www.testlongurl.com/", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+
+	// redirect with the headers a browser sends
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/%s", createdSurl.GetId()), http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1")
+	req.Header.Set("Referer", "https://t.co/abc")
+	req.Header.Set("Accept-Language", "en-GB,en;q=0.9")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s/stats", createdSurl.GetId()), http.NoBody)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var report stats.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, int64(1), report.Total)
+	assert.Equal(t, stats.GranularityDay, report.Granularity)
+	// the last 7 days span 8 day buckets
+	assert.Len(t, report.Series, 8)
+	assert.Equal(t, int64(1), report.Series[7].Calls)
+	assert.Equal(t, map[string]int64{"t.co": 1}, report.Dimensions[stats.DimensionReferrer])
+	assert.Equal(t, map[string]int64{"Safari": 1}, report.Dimensions[stats.DimensionBrowser])
+	assert.Equal(t, map[string]int64{"mobile": 1}, report.Dimensions[stats.DimensionDevice])
+	assert.Equal(t, map[string]int64{"iOS": 1}, report.Dimensions[stats.DimensionOS])
+	assert.Equal(t, map[string]int64{"en": 1}, report.Dimensions[stats.DimensionLanguage])
+
+	// a range before the click is empty
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s/stats?from=2020-01-01&to=2020-01-31T23:59:59Z", createdSurl.GetId()), http.NoBody)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, int64(0), report.Total)
+	assert.Len(t, report.Series, 31)
+
+	// hourly buckets aligned to a timezone over a custom window
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s/stats?window=12h&granularity=hour&tz=America/New_York", createdSurl.GetId()), http.NoBody)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, int64(1), report.Total)
+	assert.Equal(t, "America/New_York", report.Timezone)
+	assert.Len(t, report.Series, 13)
+	assert.Nil(t, report.TruncatedBefore)
+
+	// 90 days in a timezone reach past the hourly stats, which is flagged
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s/stats?window=90d&tz=America/New_York", createdSurl.GetId()), http.NoBody)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"truncated_before"`)
+	report = stats.Report{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.NotNil(t, report.TruncatedBefore)
+	assert.WithinDuration(t, time.Now().Add(-defaultStatsHourlyRetention), *report.TruncatedBefore, time.Hour)
+	assert.Equal(t, int64(1), report.Total)
+
+	// dates are read as midnight in the timezone
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s/stats?from=2024-01-01&to=2024-03-31&granularity=month&tz=Asia/Tokyo", createdSurl.GetId()), http.NoBody)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Len(t, report.Series, 3)
+	assert.Equal(t, "2024-01-01T00:00:00+09:00", report.Series[0].Time.Format(time.RFC3339))
+
+	// windows take the units of expiries
+	for query, window := range map[string]time.Duration{"window=2w": 14 * 24 * time.Hour, "window=1w3d": 10 * 24 * time.Hour, "window=90m": 90 * time.Minute} {
+		values, err := url.ParseQuery(query)
+		require.NoError(t, err)
+		opts, err := statsOptions(values)
+		require.NoError(t, err, query)
+		assert.Equal(t, window, opts.To.Sub(opts.From), query)
+	}
+
+	for _, query := range []string{
+		"from=yesterday",
+		"to=2020-13-01",
+		"from=2020-02-01&to=2020-01-01",
+		"granularity=minute",
+		"tz=Mars/Olympus_Mons",
+		"window=-3d",
+		"window=soon",
+		"window=30d&from=2020-01-01",
+		"window=3650d&granularity=hour",
+	} {
+		req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s/stats?%s", createdSurl.GetId(), query), http.NoBody)
+		require.NoError(t, err)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestPruneStats(t *testing.T) {
+	m := &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+	}
+	createdSurl, err := m.createShortUrl("https://www.testlongurl.com/", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+
+	m.recordClick(context.Background(), createdSurl, stats.Click{Time: time.Now()})
+	m.recordClick(context.Background(), createdSurl, stats.Click{Time: time.Now().AddDate(0, 0, -45)})
+
+	// hourly buckets default to 30 days, daily ones are kept. Pruning doesn't
+	// wait for the lock held by redirects and creates.
+	m.lock.Lock()
+	pruned := make(chan struct{})
+	go func() {
+		m.pruneStats()
+		close(pruned)
+	}()
+	select {
+	case <-pruned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pruneStats waited for the lock")
+	}
+	m.lock.Unlock()
+	report, err := m.getReport(createdSurl, stats.Options{From: time.Now().AddDate(0, 0, -60), To: time.Now()})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), report.Total)
+	report, err = m.getReport(createdSurl, stats.Options{From: time.Now().AddDate(0, 0, -60), To: time.Now(), Granularity: stats.GranularityHour})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), report.Total)
+}
+
+func TestGeoEnrichment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geo.mmdb")
+	require.NoError(t, geotest.WriteDatabase(path, map[string]geo.Location{
+		"81.2.69.0/24":  {Country: "GB", Region: "GB-ENG"},
+		"89.160.0.0/17": {Country: "SE"},
+	}))
+	reader, err := geo.Open(path)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	m := &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+		geo:     reader,
+	}
+	createdSurl, err := m.createShortUrl("https://www.testlongurl.com/", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+
+	handler := http.HandlerFunc(m.GetUrlHandleFunc)
+	for _, remoteAddr := range []string{"81.2.69.142:5000", "81.2.69.160:5000", "89.160.20.112:5000", "1.1.1.1:5000"} {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%s", createdSurl.GetId()), nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusFound, w.Code)
+	}
+
+	report, err := m.getReport(createdSurl, stats.Options{From: time.Now().Add(-time.Hour), To: time.Now()})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"GB": 2, "SE": 1, stats.Unknown: 1}, report.Dimensions[stats.DimensionCountry])
+	assert.Equal(t, map[string]int64{"GB-ENG": 2, stats.Unknown: 2}, report.Dimensions[stats.DimensionRegion])
+
+	// only the resolved location reaches the store, never the ip
+	iter := m.leveldb.NewIterator(nil, nil)
+	for iter.Next() {
+		for _, ip := range []string{"81.2.69", "89.160.20.112", "1.1.1.1"} {
+			assert.NotContains(t, string(iter.Key()), ip)
+			assert.NotContains(t, string(iter.Value()), ip)
+		}
+	}
+	iter.Release()
+
+	// behind a proxy the location comes from the entry the proxy appended,
+	// not the one the client sent
+	m.config.RateLimit.TrustProxyHeaders = true
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%s", createdSurl.GetId()), nil)
+	req.Header.Set("X-Forwarded-For", "81.2.69.142, 89.160.20.112")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	report, err = m.getReport(createdSurl, stats.Options{From: time.Now().Add(-time.Hour), To: time.Now()})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"GB": 2, "SE": 2, stats.Unknown: 1}, report.Dimensions[stats.DimensionCountry])
+}
+
+func TestGeoDatabaseMissing(t *testing.T) {
+	cfg := config.Default()
+	cfg.Geo.DatabasePath = filepath.Join(t.TempDir(), "missing.mmdb")
+	m := NewDefaultUrlManager(zap.NewNop(), NewMockDB(), cfg)
+	assert.Error(t, m.Start(context.Background(), time.Minute, time.Minute))
+}
+
+func TestUniqueVisitorsEndpoint(t *testing.T) {
+	db := NewMockDB()
+	m := NewDefaultUrlManager(zap.NewNop(), db, config.Default()).(*defaultUrlManager)
+	require.NoError(t, m.Start(context.Background(), time.Hour, time.Hour))
+	salt := m.visitorSalt
+	assert.Len(t, salt, visitorSaltLength)
+
+	createdSurl, err := m.createShortUrl("https://www.testlongurl.com/", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+
+	handler := http.HandlerFunc(m.GetUrlHandleFunc)
+	visits := []struct {
+		remoteAddr string
+		ua         string
+	}{
+		{"203.0.113.1:1000", "Firefox/121.0"},
+		{"203.0.113.1:2000", "Firefox/121.0"},
+		{"203.0.113.1:1000", "Chrome/120.0"},
+		{"203.0.113.2:1000", "Firefox/121.0"},
+	}
+	for _, visit := range visits {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/%s", createdSurl.GetId()), nil)
+		req.RemoteAddr = visit.remoteAddr
+		req.Header.Set("User-Agent", visit.ua)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusFound, w.Code)
+	}
+	m.End()
+
+	report, err := m.getReport(createdSurl, stats.Options{From: time.Now().Add(-time.Hour), To: time.Now()})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), report.Total)
+	assert.Equal(t, int64(3), report.Visitors)
+	assert.Contains(t, m.getSummary(createdSurl), "unique visitors since creation: ~3")
+
+	// the salt is kept across restarts so returning visitors are recognized
+	restarted := NewDefaultUrlManager(zap.NewNop(), db, config.Default()).(*defaultUrlManager)
+	require.NoError(t, restarted.Start(context.Background(), time.Hour, time.Hour))
+	defer restarted.End()
+	assert.Equal(t, salt, restarted.visitorSalt)
+}
+
+func TestBotClicksCountedSeparately(t *testing.T) {
+	cfg := config.Default()
+	m := NewDefaultUrlManager(zap.NewNop(), NewMockDB(), cfg).(*defaultUrlManager)
+	require.NoError(t, m.Start(context.Background(), time.Hour, time.Hour))
+
+	createdSurl, err := m.createShortUrl("https://www.testlongurl.com/", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+
+	handler := http.HandlerFunc(m.GetUrlHandleFunc)
+	visit := func(method string, ua string, headers map[string]string) {
+		req := httptest.NewRequest(method, fmt.Sprintf("/%s", createdSurl.GetId()), nil)
+		req.Header.Set("User-Agent", ua)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		// bots are still redirected
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "https://www.testlongurl.com/", w.Header().Get("Location"))
+	}
+	browser := "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0"
+	visit(http.MethodGet, browser, nil)
+	visit(http.MethodGet, "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", nil)
+	visit(http.MethodGet, "Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)", nil)
+	visit(http.MethodHead, browser, nil)
+	visit(http.MethodGet, browser, map[string]string{"Sec-Purpose": "prefetch"})
+	m.End()
+
+	report, err := m.getReport(createdSurl, stats.Options{From: time.Now().Add(-time.Hour), To: time.Now()})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), report.Total)
+	assert.Equal(t, int64(4), report.Bots)
+	assert.Equal(t, map[string]int64{"Firefox": 1}, report.Dimensions[stats.DimensionBrowser])
+
+	summary := m.getSummary(createdSurl)
+	assert.Contains(t, summary, "total calls since creation: 1 calls")
+	assert.Contains(t, summary, "total bot calls since creation: 4 calls")
+
+	// other methods are still rejected
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s", createdSurl.GetId()), nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestBotPatternsMissing(t *testing.T) {
+	cfg := config.Default()
+	cfg.Bots.PatternsPath = filepath.Join(t.TempDir(), "missing.txt")
+	m := NewDefaultUrlManager(zap.NewNop(), NewMockDB(), cfg)
+	assert.Error(t, m.Start(context.Background(), time.Minute, time.Minute))
+}
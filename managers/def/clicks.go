@@ -0,0 +1,147 @@
+package def
+
+import (
+	"context"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/metrics"
+	"github.com/moh-osman3/shortener/stats"
+	"github.com/moh-osman3/shortener/tracing"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+const (
+	defaultClickQueueSize     = 10000
+	defaultClickFlushInterval = time.Second
+	defaultClickBatchSize     = 1000
+
+	// block redirects while the click queue is full instead of dropping clicks
+	backpressureBlock = "block"
+)
+
+type clickEvent struct {
+	id    string
+	click stats.Click
+}
+
+// recordClick queues a call to the short url so the redirect doesn't wait on
+// the db write. The click worker aggregates the queue and flushes it in
+// batches. Without a running worker the click is recorded synchronously.
+func (m *defaultUrlManager) recordClick(ctx context.Context, shortUrl urls.ShortUrl, click stats.Click) {
+	if m.clicks == nil {
+		m.flushClicks(ctx, map[string][]stats.Click{shortUrl.GetId(): {click}})
+		return
+	}
+
+	event := clickEvent{id: shortUrl.GetId(), click: click}
+	if m.config.Clicks.Backpressure == backpressureBlock {
+		select {
+		case m.clicks <- event:
+		case <-ctx.Done():
+			metrics.ClicksDroppedTotal.Inc()
+		}
+		return
+	}
+
+	select {
+	case m.clicks <- event:
+	default:
+		metrics.ClicksDroppedTotal.Inc()
+		m.logger.Debug("clicks.go: click queue is full, dropping click")
+	}
+}
+
+// runClickWorker aggregates queued clicks and flushes them every interval or
+// once batchSize clicks are pending. On shutdown it drains the queue and does
+// a final flush before closing clicksDone.
+func (m *defaultUrlManager) runClickWorker(interval time.Duration, batchSize int) {
+	defer close(m.clicksDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pending := make(map[string][]stats.Click)
+	numPending := 0
+	add := func(event clickEvent) {
+		pending[event.id] = append(pending[event.id], event.click)
+		numPending++
+	}
+	flush := func() {
+		if numPending == 0 {
+			return
+		}
+		m.flushClicks(context.Background(), pending)
+		pending = make(map[string][]stats.Click)
+		numPending = 0
+	}
+
+	for {
+		select {
+		case event := <-m.clicks:
+			add(event)
+			if numPending >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-m.shutdownCh:
+			for {
+				select {
+				case event := <-m.clicks:
+					add(event)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// flushClicks adds the aggregated clicks to the stats keyspace in a single
+// batch. The short url records themselves are not rewritten. The manager lock
+// is only held to look the short urls up, so redirects don't wait on the
+// write.
+func (m *defaultUrlManager) flushClicks(ctx context.Context, pending map[string][]stats.Click) {
+	ctx, span := tracing.Tracer().Start(ctx, "counter.update")
+	defer span.End()
+
+	shortUrls := make(map[string]urls.ShortUrl, len(pending))
+	m.lock.RLock()
+	for id := range pending {
+		// deleted since the click was queued
+		if shortUrl := m.lookupShortUrl(id); shortUrl != nil {
+			shortUrls[id] = shortUrl
+		}
+	}
+	m.lock.RUnlock()
+
+	m.statsLock.Lock()
+	defer m.statsLock.Unlock()
+
+	batch := new(leveldb.Batch)
+	for id, shortUrl := range shortUrls {
+		// purged after the lookup, its stats are deleted once the retired
+		// marker is written
+		if _, err := m.leveldb.Get(retiredKey(id), nil); err == nil {
+			continue
+		}
+		clicks := pending[id]
+		recordAccess(batch, shortUrl, clicks)
+		err := stats.AddClicks(m.leveldb, batch, id, clicks)
+		if err != nil {
+			m.logger.Error("clicks.go: failed to read stats", zap.Error(err))
+		}
+	}
+
+	_, writeSpan := tracing.Tracer().Start(ctx, "store.write")
+	err := m.leveldb.Write(batch, nil)
+	writeSpan.End()
+	if err != nil {
+		m.logger.Error("clicks.go: failed to write clicks to db", zap.Error(err))
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
@@ -0,0 +1,158 @@
+package def
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cyrildever/feistel"
+	"github.com/cyrildever/feistel/common/utils/hash"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/config"
+	"github.com/moh-osman3/shortener/metrics"
+	"github.com/moh-osman3/shortener/stats"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+func newClickTestManager(clicks config.ClicksConfig) *defaultUrlManager {
+	return &defaultUrlManager{
+		cache:      make(map[string]urls.ShortUrl),
+		logger:     zap.NewNop(),
+		leveldb:    NewMockDB(),
+		shutdownCh: make(chan struct{}, 1),
+		cipher:     feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+		config:     config.Config{Clicks: clicks},
+	}
+}
+
+func storedSummary(t *testing.T, m *defaultUrlManager, id string) string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	counts, err := stats.GetCounts(m.leveldb, id, time.Now())
+	require.NoError(t, err)
+	return counts.String()
+}
+
+func expectedSummary(calls int64, visitors int64) string {
+	return stats.Counts{
+		Day: calls, Week: calls, Total: calls,
+		UniqueDay: visitors, UniqueWeek: visitors, UniqueTotal: visitors,
+	}.String()
+}
+
+func TestClicksFlushedOnShutdown(t *testing.T) {
+	// a long flush interval so nothing is written before shutdown
+	m := newClickTestManager(config.ClicksConfig{FlushInterval: config.Duration(time.Hour)})
+	createdSurl, err := m.createShortUrl("www.testlongurl.com", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, m.Start(context.Background(), time.Hour, time.Hour))
+
+	handler := http.HandlerFunc(m.GetUrlHandleFunc)
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+createdSurl.GetId(), nil))
+		assert.Equal(t, http.StatusFound, w.Code)
+	}
+
+	// redirects don't write to the db
+	assert.Equal(t, expectedSummary(0, 0), storedSummary(t, m, createdSurl.GetId()))
+
+	m.End()
+	// every redirect came from the same client
+	assert.Equal(t, expectedSummary(5, 1), storedSummary(t, m, createdSurl.GetId()))
+}
+
+func TestClicksFlushedInBatches(t *testing.T) {
+	m := newClickTestManager(config.ClicksConfig{FlushInterval: config.Duration(time.Hour), BatchSize: 3})
+	createdSurl, err := m.createShortUrl("www.testlongurl.com", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, m.Start(context.Background(), time.Hour, time.Hour))
+	defer m.End()
+
+	for i := 0; i < 3; i++ {
+		m.recordClick(context.Background(), createdSurl, stats.Click{Time: time.Now()})
+	}
+	assert.Eventually(t, func() bool {
+		return storedSummary(t, m, createdSurl.GetId()) == expectedSummary(3, 0)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClicksFlushedOnInterval(t *testing.T) {
+	m := newClickTestManager(config.ClicksConfig{FlushInterval: config.Duration(10 * time.Millisecond)})
+	createdSurl, err := m.createShortUrl("www.testlongurl.com", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	require.NoError(t, m.Start(context.Background(), time.Hour, time.Hour))
+	defer m.End()
+
+	m.recordClick(context.Background(), createdSurl, stats.Click{Time: time.Now()})
+	assert.Eventually(t, func() bool {
+		return storedSummary(t, m, createdSurl.GetId()) == expectedSummary(1, 0)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestClicksBackpressure(t *testing.T) {
+	// no worker is draining the queue so it fills up after one click
+	m := newClickTestManager(config.ClicksConfig{})
+	m.clicks = make(chan clickEvent, 1)
+	createdSurl, err := m.createShortUrl("www.testlongurl.com", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+
+	dropped := testutil.ToFloat64(metrics.ClicksDroppedTotal)
+	m.recordClick(context.Background(), createdSurl, stats.Click{Time: time.Now()})
+	m.recordClick(context.Background(), createdSurl, stats.Click{Time: time.Now()})
+	assert.Equal(t, dropped+1, testutil.ToFloat64(metrics.ClicksDroppedTotal))
+
+	// with the block policy the redirect waits until its request is cancelled
+	m.config.Clicks.Backpressure = backpressureBlock
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	m.recordClick(ctx, createdSurl, stats.Click{Time: time.Now()})
+	assert.Equal(t, dropped+2, testutil.ToFloat64(metrics.ClicksDroppedTotal))
+	assert.Len(t, m.clicks, 1)
+}
+
+func TestFlushClicksDoesNotBlockLookups(t *testing.T) {
+	m := newIdleTestManager()
+	createdSurl, err := m.createShortUrl("https://example.com/", time.Time{})
+	require.NoError(t, err)
+	id := createdSurl.GetId()
+
+	// a slow flush holds the stats lock through its write
+	m.statsLock.Lock()
+	flushed := make(chan struct{})
+	go func() {
+		defer close(flushed)
+		m.flushClicks(context.Background(), map[string][]stats.Click{id: {{Time: time.Now()}}})
+	}()
+
+	// redirects and creates don't wait for it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := m.getShortUrlFromStore(context.Background(), id)
+		assert.NoError(t, err)
+		_, err = m.createShortUrl("https://example.com/other", time.Time{})
+		assert.NoError(t, err)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookup blocked on the click flush")
+	}
+
+	m.statsLock.Unlock()
+	<-flushed
+	assert.Contains(t, m.getSummary(createdSurl), "total calls since creation: 1 calls")
+
+	// clicks of a short url purged after the lookup are dropped rather than
+	// written after its stats were deleted
+	require.NoError(t, m.leveldb.Put(retiredKey(id), []byte{}, nil))
+	m.flushClicks(context.Background(), map[string][]stats.Click{id: {{Time: time.Now()}}})
+	assert.Contains(t, m.getSummary(createdSurl), "total calls since creation: 1 calls")
+}
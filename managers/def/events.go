@@ -0,0 +1,145 @@
+package def
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/metrics"
+	"github.com/moh-osman3/shortener/stats"
+	"github.com/moh-osman3/shortener/stream"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+const defaultStreamKeepAlive = 15 * time.Second
+
+// clickEventData is the data of click events. The visitor hash is left out so
+// clicks on a public stream can't be linked to each other.
+type clickEventData struct {
+	Referrer string `json:"referrer"`
+	Browser  string `json:"browser"`
+	Device   string `json:"device"`
+	OS       string `json:"os"`
+	Language string `json:"language"`
+	Country  string `json:"country,omitempty"`
+	Region   string `json:"region,omitempty"`
+	Bot      bool   `json:"bot"`
+}
+
+// streamClick sends a redirect to the event streams.
+func (m *defaultUrlManager) streamClick(shortUrl urls.ShortUrl, click stats.Click) {
+	if m.stream == nil {
+		return
+	}
+	m.stream.Publish(stream.Event{
+		Type:   stream.EventClick,
+		LinkID: shortUrl.GetId(),
+		Time:   click.Time,
+		Data: clickEventData{
+			Referrer: click.Referrer,
+			Browser:  click.Browser,
+			Device:   click.Device,
+			OS:       click.OS,
+			Language: click.Language,
+			Country:  click.Country,
+			Region:   click.Region,
+			Bot:      click.Bot,
+		},
+	})
+}
+
+// serveEvents streams the events of linkID, or of every short url when it is
+// empty, as server-sent events until the client goes away, falls behind or the
+// manager shuts down.
+func (m *defaultUrlManager) serveEvents(w http.ResponseWriter, r *http.Request, linkID string) {
+	if m.stream == nil {
+		http.Error(w, "event streams are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, err := m.stream.Subscribe(linkID)
+	if errors.Is(err, stream.ErrTooManySubscribers) {
+		http.Error(w, "too many open event streams", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	metrics.StreamSubscribers.Inc()
+	defer func() {
+		m.stream.Unsubscribe(sub)
+		metrics.StreamSubscribers.Dec()
+	}()
+
+	keepAlive := time.Duration(m.config.Stream.KeepAlive)
+	if keepAlive <= 0 {
+		keepAlive = defaultStreamKeepAlive
+	}
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// ask nginx style proxies not to buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event, ok := <-sub.Events():
+			if !ok {
+				if sub.Dropped() {
+					metrics.StreamDroppedTotal.Inc()
+					m.logger.Debug("events.go: dropping slow event stream", zap.String("id", linkID))
+					fmt.Fprint(w, "event: dropped\ndata: {}\n\n")
+					flusher.Flush()
+				}
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				m.logger.Error("events.go: failed to marshal event", zap.Error(err))
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// EventsHandleFunc streams the events of every short url at /admin/events, or
+// of a single one with ?id=<id>.
+func (m *defaultUrlManager) EventsHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method: expected GET request", http.StatusMethodNotAllowed)
+		return
+	}
+	if !m.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if !isShortUrlId(id) {
+		http.Error(w, "invalid short url id", http.StatusBadRequest)
+		return
+	}
+	m.serveEvents(w, r, id)
+}
@@ -0,0 +1,181 @@
+package def
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/config"
+	"github.com/moh-osman3/shortener/managers"
+	"github.com/moh-osman3/shortener/stream"
+	"github.com/moh-osman3/shortener/webhooks"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents parses the server-sent events of a response body in the
+// background, skipping comments.
+func readEvents(t *testing.T, resp *http.Response) <-chan sseEvent {
+	t.Helper()
+	events := make(chan sseEvent, 100)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var event sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if event.name != "" {
+					events <- event
+				}
+				event = sseEvent{}
+			case strings.HasPrefix(line, "event: "):
+				event.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return sseEvent{}
+	}
+}
+
+func openStream(t *testing.T, url string, token string) *http.Response {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestClickEventStream(t *testing.T) {
+	cfg := config.Default()
+	cfg.AdminToken = testAdminToken
+	m := NewDefaultUrlManager(zap.NewNop(), NewMockDB(), cfg).(*defaultUrlManager)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/events", m.EventsHandleFunc)
+	mux.HandleFunc("/", m.GetUrlHandleFunc)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// shutting down the manager ends the streams, which lets the server close
+	require.NoError(t, m.Start(context.Background(), time.Hour, time.Hour))
+	defer m.End()
+
+	createdSurl, err := m.createShortUrl("https://www.testlongurl.com/", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	otherSurl, err := m.createShortUrl("https://www.other.com/", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+
+	// the stream of a link is limited to its owner and admins
+	resp := openStream(t, server.URL+"/"+createdSurl.GetId()+"/events", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = openStream(t, server.URL+"/"+createdSurl.GetId()+"/events", testAdminToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	linkEvents := readEvents(t, resp)
+
+	// the stream of every link is admin only
+	resp = openStream(t, server.URL+"/admin/events", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = openStream(t, server.URL+"/admin/events", testAdminToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	allEvents := readEvents(t, resp)
+
+	require.Eventually(t, func() bool { return m.stream.Subscribers() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/"+otherSurl.GetId(), http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0")
+	redirect, err := client.Do(req)
+	require.NoError(t, err)
+	redirect.Body.Close()
+	req.URL.Path = "/" + createdSurl.GetId()
+	redirect, err = client.Do(req)
+	require.NoError(t, err)
+	redirect.Body.Close()
+	require.NoError(t, m.setDisabled(createdSurl.GetId(), nil))
+
+	event := nextEvent(t, linkEvents)
+	assert.Equal(t, stream.EventClick, event.name)
+	var click struct {
+		LinkID string         `json:"link_id"`
+		Data   clickEventData `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(event.data), &click))
+	assert.Equal(t, createdSurl.GetId(), click.LinkID)
+	assert.Equal(t, "Chrome", click.Data.Browser)
+	assert.Equal(t, webhooks.EventUpdated, nextEvent(t, linkEvents).name)
+
+	assert.Equal(t, stream.EventClick, nextEvent(t, allEvents).name)
+	assert.Equal(t, stream.EventClick, nextEvent(t, allEvents).name)
+	assert.Equal(t, webhooks.EventUpdated, nextEvent(t, allEvents).name)
+}
+
+func TestSlowEventStreamIsDropped(t *testing.T) {
+	cfg := config.Default()
+	cfg.Stream.BufferSize = 1
+	m := NewDefaultUrlManager(zap.NewNop(), NewMockDB(), cfg).(*defaultUrlManager)
+	createdSurl, err := m.createShortUrl("https://www.testlongurl.com/", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+
+	// nothing reads from the subscription, so the second click overflows it
+	sub, err := m.stream.Subscribe(createdSurl.GetId())
+	require.NoError(t, err)
+	m.streamClick(createdSurl, m.newClick(httptest.NewRequest(http.MethodGet, "/", nil)))
+	m.streamClick(createdSurl, m.newClick(httptest.NewRequest(http.MethodGet, "/", nil)))
+	assert.True(t, sub.Dropped())
+	assert.Equal(t, 0, m.stream.Subscribers())
+}
+
+func TestLinkEventStreamOwner(t *testing.T) {
+	m := newIdleTestManager()
+	req, err := http.NewRequest(http.MethodPost, "/create", strings.NewReader(`{"url":"https://example.com/"}`))
+	require.NoError(t, err)
+	req.Header.Set(managers.ApiKeyHeader, "owner-key")
+	w := httptest.NewRecorder()
+	m.CreateUrlHandleFunc(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	id := strings.TrimPrefix(w.Body.String(), "Successfully created short url: http://localhost:3030/")
+
+	// other api keys can't follow the short url. The test manager has no
+	// event streams, so the owner gets past the check to a 503.
+	for key, status := range map[string]int{"": http.StatusForbidden, "other-key": http.StatusForbidden, "owner-key": http.StatusServiceUnavailable} {
+		req := httptest.NewRequest(http.MethodGet, "/"+id+"/events", nil)
+		req.Header.Set(managers.ApiKeyHeader, key)
+		w := httptest.NewRecorder()
+		m.GetUrlHandleFunc(w, req)
+		assert.Equal(t, status, w.Code, key)
+	}
+}
@@ -0,0 +1,299 @@
+package def
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/managers"
+	"github.com/moh-osman3/shortener/urls"
+	"github.com/moh-osman3/shortener/webhooks"
+)
+
+const (
+	// expired short urls are moved under this prefix by the expiry scans and
+	// kept for the grace period, so they answer 410 Gone and can be renewed
+	expiredPrefix = "expired:"
+	// once an expired or deleted short url is purged only an empty marker is
+	// kept under this prefix, so its id is never handed out again
+	retiredPrefix = "retired:"
+
+	defaultGracePeriod = 30 * 24 * time.Hour
+)
+
+var errNoShortUrl = errors.New("expired.go: short url does not exist or its grace period ended")
+
+type expiredEntry struct {
+	ExpiredAt time.Time       `json:"expired_at"`
+	ShortUrl  json.RawMessage `json:"short_url"`
+}
+
+type renewData struct {
+	Id        string `json:"id"`
+	Expiry    string `json:"expiry"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+func expiredKey(id string) []byte {
+	return []byte(expiredPrefix + id)
+}
+
+func retiredKey(id string) []byte {
+	return []byte(retiredPrefix + id)
+}
+
+// ownerOf returns the owner of short urls created by the request, the hash of
+// its api key, or "" without one.
+func ownerOf(r *http.Request) string {
+	key := r.Header.Get(managers.ApiKeyHeader)
+	if key == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// isReserved reports whether the id belongs to a deleted, expired or purged
+// short url and must not be handed out again. The caller must hold the lock.
+func (m *defaultUrlManager) isReserved(id string) bool {
+	for _, key := range [][]byte{trashKey(id), expiredKey(id), retiredKey(id)} {
+		if _, err := m.leveldb.Get(key, nil); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// expireShortUrl moves an expired short url out of the cache and the live
+// keyspace into the expired namespace and returns it. Its stats are kept for
+// the grace period so they are still there if it is renewed. The scans find
+// expired short urls before taking the write lock, so the short url is looked
+// up again: nil is returned if it was renewed, disabled or deleted since.
+func (m *defaultUrlManager) expireShortUrl(id string) (urls.ShortUrl, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	shortUrl := m.lookupShortUrl(id)
+	if shortUrl == nil || !m.shouldExpire(shortUrl) {
+		return nil, nil
+	}
+	record, err := shortUrl.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	entry, err := json.Marshal(expiredEntry{ExpiredAt: time.Now(), ShortUrl: record})
+	if err != nil {
+		return nil, err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(expiredKey(id), entry)
+	batch.Delete([]byte(id))
+	if err := m.leveldb.Write(batch, nil); err != nil {
+		return nil, err
+	}
+	m.deleteShortUrlFromCache(id)
+	return shortUrl, nil
+}
+
+// lookupExpired returns the short url kept under id in the expired namespace,
+// or nil if there is none. The caller must hold the lock.
+func (m *defaultUrlManager) lookupExpired(id string) urls.ShortUrl {
+	if !isShortUrlId(id) {
+		return nil
+	}
+	val, err := m.leveldb.Get(expiredKey(id), nil)
+	if err != nil {
+		return nil
+	}
+	var entry expiredEntry
+	if err := json.Unmarshal(val, &entry); err != nil {
+		return nil
+	}
+	shortUrl := urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
+	if err := shortUrl.Unmarshal(entry.ShortUrl); err != nil {
+		return nil
+	}
+	return shortUrl
+}
+
+// expiredAt returns when the short url expired, at its expiry or at the end
+// of its idle ttl.
+func (m *defaultUrlManager) expiredAt(shortUrl urls.ShortUrl) time.Time {
+	expiry := shortUrl.GetExpiry()
+	if !expiry.IsZero() && time.Now().After(expiry) {
+		return expiry
+	}
+	return urls.IdleExpiry(shortUrl, m.lastAccess(shortUrl.GetId()))
+}
+
+// renewShortUrl moves the expiry of a live or expired short url to expiresAt
+// and brings expired ones back into the live keyspace.
+func (m *defaultUrlManager) renewShortUrl(id string, expiresAt time.Time) (urls.ShortUrl, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	existing := m.lookupShortUrl(id)
+	expired := false
+	if existing == nil {
+		existing = m.lookupExpired(id)
+		expired = true
+	}
+	if existing == nil {
+		return nil, errNoShortUrl
+	}
+	// requests may still be reading the cached short url, so a changed copy
+	// replaces it
+	shortUrl, err := copyShortUrl(existing)
+	if err != nil {
+		return nil, err
+	}
+
+	shortUrl.SetExpiry(expiresAt)
+	record, err := shortUrl.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put([]byte(id), record)
+	if expired {
+		batch.Delete(expiredKey(id))
+	}
+	// renewing counts as a use, so an idle short url doesn't expire again
+	// right away
+	if shortUrl.GetOptions().IdleTTL > 0 {
+		batch.Put(accessKey(id), []byte(strconv.FormatInt(time.Now().UnixNano(), 10)))
+	}
+	if err := m.leveldb.Write(batch, nil); err != nil {
+		return nil, err
+	}
+	m.cache[id] = shortUrl
+	m.publish(webhooks.EventUpdated, shortUrl)
+	return shortUrl, nil
+}
+
+// purgeExpired permanently deletes expired short urls whose grace period
+// ended, keeping only their retired marker.
+func (m *defaultUrlManager) purgeExpired() {
+	grace := time.Duration(m.config.Expiry.GracePeriod)
+	if grace <= 0 {
+		grace = defaultGracePeriod
+	}
+
+	var purge []string
+	m.lock.RLock()
+	iter := m.leveldb.NewIterator(util.BytesPrefix([]byte(expiredPrefix)), nil)
+	for iter.Next() {
+		var entry expiredEntry
+		if err := json.Unmarshal(iter.Value(), &entry); err != nil {
+			continue
+		}
+		if time.Since(entry.ExpiredAt) > grace {
+			purge = append(purge, strings.TrimPrefix(string(iter.Key()), expiredPrefix))
+		}
+	}
+	iter.Release()
+	m.lock.RUnlock()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, id := range purge {
+		err := m.retire(expiredKey(id), id)
+		if err != nil {
+			m.logger.Debug("expired.go: error purging expired short url", zap.Error(err))
+		}
+	}
+}
+
+// retire replaces the entry under key with the retired marker of id and
+// deletes its stats. The caller must hold the lock.
+func (m *defaultUrlManager) retire(key []byte, id string) error {
+	batch := new(leveldb.Batch)
+	batch.Delete(key)
+	batch.Put(retiredKey(id), []byte{})
+	if err := m.leveldb.Write(batch, nil); err != nil {
+		return err
+	}
+	m.deleteStats(id)
+	return nil
+}
+
+// isOwner reports whether the request may manage the short url, e.g. renew
+// it: admins can manage every short url, api keys the ones they created.
+func (m *defaultUrlManager) isOwner(r *http.Request, shortUrl urls.ShortUrl) bool {
+	if m.isAdmin(r) {
+		return true
+	}
+	owner := shortUrl.GetOptions().Owner
+	if owner == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(ownerOf(r)), []byte(owner)) == 1
+}
+
+func (m *defaultUrlManager) RenewUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method: expected POST request", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	var renewData renewData
+	json.Unmarshal(body, &renewData)
+	if !isShortUrlId(renewData.Id) {
+		http.Error(w, "invalid short url id", http.StatusBadRequest)
+		return
+	}
+
+	m.lock.RLock()
+	shortUrl := m.lookupShortUrl(renewData.Id)
+	if shortUrl == nil {
+		shortUrl = m.lookupExpired(renewData.Id)
+	}
+	m.lock.RUnlock()
+	if shortUrl == nil {
+		http.Error(w, errNoShortUrl.Error(), http.StatusNotFound)
+		return
+	}
+	if !m.isOwner(r, shortUrl) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if shortUrl.GetDisabled() != nil {
+		http.Error(w, "short url is disabled and can not be renewed", http.StatusConflict)
+		return
+	}
+
+	expiresAt, err := urls.ResolveExpiry(renewData.Expiry, renewData.ExpiresAt, time.Now(), m.config.Expiry)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, err = m.renewShortUrl(renewData.Id, expiresAt)
+	if errors.Is(err, errNoShortUrl) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m.logger.Info("expired.go: renewed short url", zap.String("id", renewData.Id))
+	io.WriteString(w, fmt.Sprintf("Successfully renewed short url: http://localhost:3030/%s", renewData.Id))
+}
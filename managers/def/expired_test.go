@@ -0,0 +1,227 @@
+package def
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moh-osman3/shortener/managers"
+	"github.com/moh-osman3/shortener/stats"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+// ageExpiredEntry moves the time a short url expired back by d.
+func ageExpiredEntry(t *testing.T, m *defaultUrlManager, id string, d time.Duration) {
+	t.Helper()
+	val, err := m.leveldb.Get(expiredKey(id), nil)
+	require.NoError(t, err)
+	var entry expiredEntry
+	require.NoError(t, json.Unmarshal(val, &entry))
+	entry.ExpiredAt = entry.ExpiredAt.Add(-d)
+	val, err = json.Marshal(entry)
+	require.NoError(t, err)
+	require.NoError(t, m.leveldb.Put(expiredKey(id), val, nil))
+}
+
+func TestExpiredGracePeriod(t *testing.T) {
+	m := newIdleTestManager()
+	expiry := time.Now().Add(-time.Minute)
+	shortUrl, err := m.createShortUrl("https://example.com/expired", expiry)
+	require.NoError(t, err)
+	id := shortUrl.GetId()
+	m.recordClick(context.Background(), shortUrl, stats.Click{Time: time.Now().Add(-2 * time.Minute)})
+
+	m.scanAndDeleteCache()
+	_, err = m.leveldb.Get([]byte(id), nil)
+	assert.Error(t, err)
+	require.NotNil(t, m.lookupExpired(id))
+
+	// it answers 410 with when it expired during the grace period
+	w := resolve(m, id)
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), "This short url expired on "+expiry.UTC().Format(time.RFC1123))
+	w = resolve(m, id+"/summary")
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Equal(t, int64(1), m.getCounts(shortUrl).Total)
+
+	// the grace period hasn't ended yet
+	m.purgeExpired()
+	assert.NotNil(t, m.lookupExpired(id))
+
+	ageExpiredEntry(t, m, id, defaultGracePeriod)
+	m.purgeExpired()
+	assert.Nil(t, m.lookupExpired(id))
+	_, err = m.leveldb.Get(retiredKey(id), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), m.getCounts(shortUrl).Total)
+	assert.Equal(t, http.StatusNotFound, resolve(m, id).Code)
+}
+
+func TestReservedIdsAreNotReissued(t *testing.T) {
+	m := newIdleTestManager()
+	expired, err := m.createShortUrl("https://example.com/expired", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	deleted, err := m.createShortUrl("https://example.com/deleted", time.Time{})
+	require.NoError(t, err)
+	require.NoError(t, m.moveToTrash(deleted.GetId()))
+	m.config.TrashRetention = 1
+
+	m.scanAndDeleteCache()
+	ageExpiredEntry(t, m, expired.GetId(), defaultGracePeriod)
+	m.purgeExpired()
+	m.purgeTrash()
+	for _, id := range []string{expired.GetId(), deleted.GetId()} {
+		_, err = m.leveldb.Get(retiredKey(id), nil)
+		assert.NoError(t, err, id)
+	}
+
+	// a restarted manager starts over at the first sequence id
+	m.numUrls = 0
+	reissued, err := m.createShortUrl("https://example.com/new", time.Time{})
+	require.NoError(t, err)
+	assert.NotEqual(t, expired.GetId(), reissued.GetId())
+	assert.NotEqual(t, deleted.GetId(), reissued.GetId())
+}
+
+func newRenewRequest(t *testing.T, apiKey string, body string) *http.Request {
+	req, err := http.NewRequest(http.MethodPost, "/renew", bytes.NewBuffer([]byte(body)))
+	require.NoError(t, err)
+	if apiKey != "" {
+		req.Header.Set(managers.ApiKeyHeader, apiKey)
+	}
+	return req
+}
+
+func TestRenewUrl(t *testing.T) {
+	m := newIdleTestManager()
+	m.config.AdminToken = testAdminToken
+	handler := http.HandlerFunc(m.RenewUrlHandleFunc)
+
+	// the api key that creates a short url owns it
+	w := httptest.NewRecorder()
+	m.CreateUrlHandleFunc(w, newRenewRequest(t, "owner-key", `{"url":"https://example.com/campaign","expiry":"1h"}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	id := strings.TrimPrefix(w.Body.String(), "Successfully created short url: http://localhost:3030/")
+	m.lock.RLock()
+	shortUrl := m.lookupShortUrl(id)
+	m.lock.RUnlock()
+	assert.Equal(t, ownerOf(newRenewRequest(t, "owner-key", "")), shortUrl.GetOptions().Owner)
+
+	shortUrl.SetExpiry(time.Now().Add(-time.Minute))
+	m.scanAndDeleteCache()
+	require.NotNil(t, m.lookupExpired(id))
+
+	body := `{"id":"` + id + `","expiry":"30d"}`
+	for _, apiKey := range []string{"", "other-key"} {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, newRenewRequest(t, apiKey, body))
+		assert.Equal(t, http.StatusForbidden, w.Code, apiKey)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newRenewRequest(t, "owner-key", `{"id":"`+id+`","expiry":"soon"}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newRenewRequest(t, "owner-key", body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, m.lookupExpired(id))
+	w = resolve(m, id)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/campaign", w.Header().Get("Location"))
+
+	// admins can renew live short urls too
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newAdminRequest(t, http.MethodPost, "/renew", `{"id":"`+id+`","expiry":"never"}`))
+	assert.Equal(t, http.StatusOK, w.Code)
+	m.lock.RLock()
+	assert.True(t, m.lookupShortUrl(id).GetExpiry().IsZero())
+	m.lock.RUnlock()
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newAdminRequest(t, http.MethodPost, "/renew", `{"id":"missing"}`))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newAdminRequest(t, http.MethodGet, "/renew", ""))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestRenewIdleUrl(t *testing.T) {
+	m := newIdleTestManager()
+	storeShortUrl(t, m, "idle", time.Now().Add(-2*time.Hour), urls.Options{IdleTTL: time.Hour})
+	m.scanAndDeleteCache()
+	require.NotNil(t, m.lookupExpired("idle"))
+
+	_, err := m.renewShortUrl("idle", time.Time{})
+	require.NoError(t, err)
+	_, err = m.getShortUrlFromStore(context.Background(), "idle")
+	assert.NoError(t, err)
+}
+
+func TestRenewReplacesCachedUrl(t *testing.T) {
+	m := newIdleTestManager()
+	createdSurl, err := m.createShortUrl("https://example.com/", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	id := createdSurl.GetId()
+
+	// requests resolving the short url while it is renewed keep reading the
+	// record they looked up
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			resolve(m, id)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		_, err := m.renewShortUrl(id, time.Now().Add(time.Duration(i+2)*time.Hour))
+		require.NoError(t, err)
+	}
+	<-done
+
+	renewed, err := m.renewShortUrl(id, time.Time{})
+	require.NoError(t, err)
+	assert.False(t, createdSurl.GetExpiry().IsZero())
+	assert.True(t, renewed.GetExpiry().IsZero())
+	m.lock.RLock()
+	assert.Same(t, renewed, m.lookupShortUrl(id))
+	m.lock.RUnlock()
+}
+
+func TestExpireLooksUpShortUrlAgain(t *testing.T) {
+	m := newIdleTestManager()
+	create := func() string {
+		createdSurl, err := m.createShortUrl(fmt.Sprintf("https://example.com/%d", m.numUrls), time.Now().Add(time.Millisecond))
+		require.NoError(t, err)
+		return createdSurl.GetId()
+	}
+	renewed, disabled, trashed := create(), create(), create()
+	time.Sleep(5 * time.Millisecond)
+
+	// the scans found them expired, then they changed before the write lock
+	// was taken
+	_, err := m.renewShortUrl(renewed, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, m.setDisabled(disabled, &urls.DisabledState{Reason: "spam"}))
+	require.NoError(t, m.moveToTrash(trashed))
+
+	for _, id := range []string{renewed, disabled, trashed} {
+		shortUrl, err := m.expireShortUrl(id)
+		require.NoError(t, err)
+		assert.Nil(t, shortUrl, id)
+		_, err = m.leveldb.Get(expiredKey(id), nil)
+		assert.Error(t, err, id)
+	}
+	m.lock.RLock()
+	assert.NotNil(t, m.lookupShortUrl(renewed))
+	assert.Equal(t, "spam", m.lookupShortUrl(disabled).GetDisabled().Reason)
+	m.lock.RUnlock()
+}
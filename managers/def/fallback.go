@@ -0,0 +1,163 @@
+package def
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/metrics"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+// fallback reasons, used as the label of the fallback redirect metric
+const (
+	fallbackExpired    = "expired"
+	fallbackDisabled   = "disabled"
+	fallbackClickLimit = "click_limit"
+	fallbackNotFound   = "not_found"
+
+	// the fallback url of all domains without one of their own
+	anyDomain = "*"
+)
+
+// errorPageTemplate is the built in page for short urls that don't redirect.
+var errorPageTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{if .Reason}}<p>Reason: {{.Reason}}</p>{{end}}
+</body>
+</html>
+`))
+
+// loadFallbackPages replaces the built in 404 and 410 pages with the
+// configured ones.
+func (m *defaultUrlManager) loadFallbackPages() error {
+	if path := m.config.Fallback.NotFoundPagePath; path != "" {
+		tmpl, err := template.ParseFiles(path)
+		if err != nil {
+			return err
+		}
+		m.notFoundTemplate = tmpl
+	}
+	if path := m.config.Fallback.GonePagePath; path != "" {
+		tmpl, err := template.ParseFiles(path)
+		if err != nil {
+			return err
+		}
+		m.goneTemplate = tmpl
+	}
+	return nil
+}
+
+// fallbackUrl returns where a request for a short url that doesn't redirect
+// is sent instead, or "" to serve an error page. The short url's own fallback
+// url comes first, then the one of the domain it was requested on. shortUrl
+// is nil when it doesn't exist or its own fallback url must not be used.
+func (m *defaultUrlManager) fallbackUrl(r *http.Request, shortUrl urls.ShortUrl) string {
+	if shortUrl != nil && shortUrl.GetOptions().FallbackUrl != "" {
+		return shortUrl.GetOptions().FallbackUrl
+	}
+
+	domains := m.config.Fallback.Urls
+	if len(domains) == 0 {
+		return ""
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if fallback, ok := domains[strings.ToLower(host)]; ok {
+		return fallback
+	}
+	return domains[anyDomain]
+}
+
+// redirectToFallback sends the request to the fallback url, if there is one,
+// and reports whether it did.
+func (m *defaultUrlManager) redirectToFallback(w http.ResponseWriter, r *http.Request, shortUrl urls.ShortUrl, reason string) bool {
+	fallback := m.fallbackUrl(r, shortUrl)
+	if fallback == "" {
+		return false
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, fallback, http.StatusFound)
+	metrics.FallbackRedirectsTotal.WithLabelValues(reason).Inc()
+	return true
+}
+
+// serveErrorPage renders the 404 page for not found and the 410 page for
+// every other code, using the configured templates when there are any.
+func (m *defaultUrlManager) serveErrorPage(w http.ResponseWriter, code int, message string, reason string) {
+	tmpl := m.goneTemplate
+	if code == http.StatusNotFound {
+		tmpl = m.notFoundTemplate
+	}
+	if tmpl == nil {
+		tmpl = errorPageTemplate
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	err := tmpl.Execute(w, struct {
+		Title   string
+		Message string
+		Reason  string
+	}{
+		Title:   http.StatusText(code),
+		Message: message,
+		Reason:  reason,
+	})
+	if err != nil {
+		m.logger.Error("fallback.go: failed to render error page", zap.Error(err))
+	}
+}
+
+// serveExpired answers a redirect to a short url that expired and hasn't been
+// purged yet.
+func (m *defaultUrlManager) serveExpired(w http.ResponseWriter, r *http.Request, shortUrl urls.ShortUrl) {
+	if m.redirectToFallback(w, r, shortUrl, fallbackExpired) {
+		return
+	}
+	m.lock.RLock()
+	expiredAt := m.expiredAt(shortUrl)
+	m.lock.RUnlock()
+	message := fmt.Sprintf("This short url expired on %s.", expiredAt.UTC().Format(time.RFC1123))
+	m.serveErrorPage(w, http.StatusGone, message, "")
+}
+
+// serveClickLimit answers a redirect to a short url that used up its clicks.
+func (m *defaultUrlManager) serveClickLimit(w http.ResponseWriter, r *http.Request, shortUrl urls.ShortUrl) {
+	if m.redirectToFallback(w, r, shortUrl, fallbackClickLimit) {
+		return
+	}
+	m.serveErrorPage(w, http.StatusGone, "This short url has reached its click limit.", "")
+}
+
+// serveNotFound answers a redirect to a short url that doesn't exist. Short
+// urls that moved themselves to the trash after their last click are still
+// answered as being over their click limit.
+func (m *defaultUrlManager) serveNotFound(w http.ResponseWriter, r *http.Request, id string) {
+	m.lock.RLock()
+	trashed := m.trashedShortUrl(id)
+	m.lock.RUnlock()
+	if trashed != nil && trashed.GetOptions().DeleteAfterMaxClicks {
+		used, err := m.usedClicks(id)
+		if err == nil && used >= trashed.GetOptions().MaxClicks {
+			m.serveClickLimit(w, r, trashed)
+			return
+		}
+	}
+
+	if m.redirectToFallback(w, r, nil, fallbackNotFound) {
+		return
+	}
+	m.serveErrorPage(w, http.StatusNotFound, "This short url does not exist.", "")
+}
@@ -0,0 +1,142 @@
+package def
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moh-osman3/shortener/urls"
+)
+
+func resolveOn(m *defaultUrlManager, host string, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Host = host
+	w := httptest.NewRecorder()
+	m.GetUrlHandleFunc(w, req)
+	return w
+}
+
+func TestFallbackPages(t *testing.T) {
+	m := newIdleTestManager()
+
+	w := resolve(m, "missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "This short url does not exist.")
+	w = resolveOn(m, "sho.rt", "/missing/summary")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	expired, err := m.createShortUrl("https://example.com/expired", time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	w = resolve(m, expired.GetId())
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), "This short url expired on")
+	w = resolveOn(m, "sho.rt", "/"+expired.GetId()+"/summary")
+	assert.Equal(t, http.StatusGone, w.Code)
+
+	limited, err := m.createShortUrlWithOptions("https://example.com/once", time.Time{}, time.Time{}, urls.Options{MaxClicks: 1})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resolve(m, limited.GetId()).Code)
+	w = resolve(m, limited.GetId())
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), "click limit")
+}
+
+func TestFallbackUrls(t *testing.T) {
+	m := newIdleTestManager()
+	m.config.Fallback.Urls = map[string]string{
+		"sho.rt": "https://example.com/home",
+		"*":      "https://example.com/",
+	}
+	options := urls.Options{FallbackUrl: "https://example.com/campaign-over", MaxClicks: 1}
+
+	// the short url's own fallback url comes first
+	expired, err := m.createShortUrlWithOptions("https://example.com/expired", time.Now().Add(-time.Minute), time.Time{}, options)
+	require.NoError(t, err)
+	w := resolveOn(m, "sho.rt", "/"+expired.GetId())
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/campaign-over", w.Header().Get("Location"))
+
+	limited, err := m.createShortUrlWithOptions("https://example.com/once", time.Time{}, time.Time{}, options)
+	require.NoError(t, err)
+	w = resolveOn(m, "sho.rt", "/"+limited.GetId())
+	assert.Equal(t, "https://example.com/once", w.Header().Get("Location"))
+	w = resolveOn(m, "sho.rt", "/"+limited.GetId())
+	assert.Equal(t, "https://example.com/campaign-over", w.Header().Get("Location"))
+
+	// then the domain's
+	w = resolveOn(m, "sho.rt:3030", "/missing")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/home", w.Header().Get("Location"))
+	w = resolveOn(m, "other.domain", "/missing")
+	assert.Equal(t, "https://example.com/", w.Header().Get("Location"))
+
+	// disabled short urls only use the domain's
+	disabled, err := m.createShortUrlWithOptions("https://example.com/phish", time.Time{}, time.Time{}, options)
+	require.NoError(t, err)
+	err = m.setDisabled(disabled.GetId(), &urls.DisabledState{Reason: "phishing", Timestamp: time.Now()})
+	require.NoError(t, err)
+	w = resolveOn(m, "sho.rt", "/"+disabled.GetId())
+	assert.Equal(t, "https://example.com/home", w.Header().Get("Location"))
+}
+
+func TestFallbackAfterOneTimeUrlDeletesItself(t *testing.T) {
+	m := newIdleTestManager()
+	options := urls.Options{FallbackUrl: "https://example.com/used", MaxClicks: 1, DeleteAfterMaxClicks: true}
+	shortUrl, err := m.createShortUrlWithOptions("https://example.com/secret", time.Time{}, time.Time{}, options)
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://example.com/secret", resolve(m, shortUrl.GetId()).Header().Get("Location"))
+	w := resolve(m, shortUrl.GetId())
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/used", w.Header().Get("Location"))
+}
+
+func TestFallbackPagesFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	notFound := filepath.Join(dir, "404.html")
+	gone := filepath.Join(dir, "410.html")
+	require.NoError(t, os.WriteFile(notFound, []byte(`<p>branded {{.Title}}</p>`), 0o644))
+	require.NoError(t, os.WriteFile(gone, []byte(`<p>branded {{.Title}}: {{.Message}} {{.Reason}}</p>`), 0o644))
+
+	m := newIdleTestManager()
+	m.config.Fallback.NotFoundPagePath = notFound
+	m.config.Fallback.GonePagePath = gone
+	require.NoError(t, m.loadFallbackPages())
+
+	w := resolve(m, "missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "<p>branded Not Found</p>", w.Body.String())
+
+	disabled, err := m.createShortUrl("https://example.com/phish", time.Time{})
+	require.NoError(t, err)
+	err = m.setDisabled(disabled.GetId(), &urls.DisabledState{Reason: "phishing", Timestamp: time.Now()})
+	require.NoError(t, err)
+	w = resolve(m, disabled.GetId())
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), "branded Gone: This short url has been disabled")
+	assert.Contains(t, w.Body.String(), "phishing")
+
+	m.config.Fallback.GonePagePath = filepath.Join(dir, "missing.html")
+	assert.Error(t, m.loadFallbackPages())
+}
+
+func TestCreateUrlFallbackUrl(t *testing.T) {
+	m := newIdleTestManager()
+	id := createLimitedUrl(t, m, `{"url":"https://example.com","fallback_url":"example.com/over"}`)
+	m.lock.RLock()
+	assert.Equal(t, "https://example.com/over", m.lookupShortUrl(id).GetOptions().FallbackUrl)
+	m.lock.RUnlock()
+
+	req, err := http.NewRequest(http.MethodPost, "/create", strings.NewReader(`{"url":"https://example.com","fallback_url":"javascript:alert(1)"}`))
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	m.CreateUrlHandleFunc(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,67 @@
+package def
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/moh-osman3/shortener/urls"
+)
+
+// isReportPath reports whether the path asks for the summary, stats or events
+// of a short url instead of a redirect.
+func isReportPath(paths []string) bool {
+	if len(paths) != 2 {
+		return false
+	}
+	switch paths[1] {
+	case "summary", "stats", "events":
+		return true
+	}
+	return false
+}
+
+// isRedirect reports whether the path redirects, either to the short url
+// itself or with a path suffix that is forwarded to its long url.
+func isRedirect(paths []string, shortUrl urls.ShortUrl) bool {
+	if len(paths) == 1 {
+		return true
+	}
+	return !isReportPath(paths) && shortUrl != nil && shortUrl.GetOptions().ForwardPath
+}
+
+// forwardedDestination returns where a redirect goes: the long url, with the
+// path suffix and query string of the request if the short url forwards them.
+func forwardedDestination(r *http.Request, shortUrl urls.ShortUrl) (string, error) {
+	options := shortUrl.GetOptions()
+	suffix := ""
+	if options.ForwardPath {
+		// the escaped path keeps encoded slashes in the suffix intact
+		_, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+		if ok {
+			suffix = "/" + rest
+		}
+	}
+
+	var query url.Values
+	if options.ForwardQuery {
+		query = r.URL.Query()
+		// the access token is meant for the shortener, not the destination
+		if options.Signed {
+			query.Del(tokenExpiresParam)
+			query.Del(tokenKeyParam)
+			query.Del(tokenSignatureParam)
+		}
+	}
+	return urls.Forward(shortUrl.GetLongUrl(), suffix, query, options.QueryPrecedence)
+}
+
+// isDestinationBlocked checks a forwarded destination against the blocklist,
+// since the path and query of a request can make it match a rule its long url
+// doesn't.
+func (m *defaultUrlManager) isDestinationBlocked(shortUrl urls.ShortUrl, destination string) bool {
+	if m.blocklist == nil || destination == shortUrl.GetLongUrl() {
+		return false
+	}
+	return m.blocklist.CheckUrl(destination) != nil
+}
@@ -0,0 +1,91 @@
+package def
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/blocklist"
+	"github.com/moh-osman3/shortener/config"
+)
+
+func TestForwardPath(t *testing.T) {
+	m := newIdleTestManager()
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/docs","forward_path":true}`)
+
+	w := resolve(m, id+"/v2/intro")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/docs/v2/intro", w.Header().Get("Location"))
+	w = resolve(m, id)
+	assert.Equal(t, "https://example.com/docs", w.Header().Get("Location"))
+
+	// summary, stats and events are not forwarded
+	w = resolve(m, id+"/summary")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "total calls since creation: 2 calls")
+
+	// dot segments can't climb out of the long url's path
+	assert.Equal(t, http.StatusBadRequest, resolve(m, id+"/%2e%2e/admin").Code)
+
+	// short urls that don't forward paths reject them, and the query string
+	// is dropped
+	plain := createLimitedUrl(t, m, `{"url":"https://example.com/plain"}`)
+	assert.Equal(t, http.StatusBadRequest, resolve(m, plain+"/v2/intro").Code)
+	w = resolve(m, plain+"?ref=abc")
+	assert.Equal(t, "https://example.com/plain", w.Header().Get("Location"))
+}
+
+func TestForwardQuery(t *testing.T) {
+	m := newIdleTestManager()
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/?utm_source=mail","forward_query":true}`)
+	w := resolve(m, id+"?utm_source=ads&ref=abc")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/?ref=abc&utm_source=mail", w.Header().Get("Location"))
+
+	id = createLimitedUrl(t, m, `{"url":"https://example.com/?utm_source=mail","forward_query":true,"query_precedence":"request"}`)
+	w = resolve(m, id+"?utm_source=ads&ref=abc")
+	assert.Equal(t, "https://example.com/?ref=abc&utm_source=ads", w.Header().Get("Location"))
+
+	// the access token of signed short urls is not forwarded
+	m.config.Signing.Keys = []config.SigningKey{testNewKey}
+	id = createLimitedUrl(t, m, `{"url":"https://example.com/report","signed":true,"forward_query":true,"forward_path":true}`)
+	token, err := m.signToken(id, time.Now().Add(defaultTokenTTL))
+	require.NoError(t, err)
+	token.Set("page", "2")
+	w = resolve(m, id+"/q3?"+token.Encode())
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/report/q3?page=2", w.Header().Get("Location"))
+
+	for _, body := range []string{
+		`{"url":"https://example.com","query_precedence":"request"}`,
+		`{"url":"https://example.com","forward_query":true,"query_precedence":"both"}`,
+	} {
+		req, err := http.NewRequest(http.MethodPost, "/create", strings.NewReader(body))
+		require.NoError(t, err)
+		w = httptest.NewRecorder()
+		m.CreateUrlHandleFunc(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
+
+func TestForwardedDestinationBlocked(t *testing.T) {
+	m := newIdleTestManager()
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/","forward_path":true}`)
+
+	rulesPath := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(rulesPath, []byte("re:example\\.com/malware\n"), 0o600))
+	var err error
+	m.blocklist, err = blocklist.New(rulesPath, false, zap.NewNop())
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusForbidden, resolve(m, id+"/malware/payload").Code)
+	assert.Equal(t, http.StatusFound, resolve(m, id+"/docs").Code)
+}
@@ -2,11 +2,18 @@ package def
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/metrics"
+	"github.com/moh-osman3/shortener/urls"
 )
 
 type deleteData struct {
@@ -26,8 +33,21 @@ func (m *defaultUrlManager) DeleteUrlHandleFunc(w http.ResponseWriter, r *http.R
 	}
 	var deleteData deleteData
 	json.Unmarshal(body, &deleteData)
+	if !isShortUrlId(deleteData.Id) {
+		http.Error(w, "invalid short url id", http.StatusBadRequest)
+		return
+	}
 
-	err = m.deleteKeyFromCacheAndDb(deleteData.Id)
+	// disabled short urls are kept as evidence and can't be deleted
+	m.lock.RLock()
+	existing := m.lookupShortUrl(deleteData.Id)
+	m.lock.RUnlock()
+	if existing != nil && existing.GetDisabled() != nil {
+		http.Error(w, "short url is disabled and can not be deleted", http.StatusConflict)
+		return
+	}
+
+	err = m.moveToTrash(deleteData.Id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -38,34 +58,137 @@ func (m *defaultUrlManager) DeleteUrlHandleFunc(w http.ResponseWriter, r *http.R
 
 func (m *defaultUrlManager) GetUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
-	if r.Method != http.MethodGet {
-		http.Error(w, "Invalid method: expected GET request", http.StatusMethodNotAllowed)
+	// link checkers and unfurlers send HEAD requests, they are redirected too
+	// but counted as bots. The password form of protected short urls is
+	// posted back to the short url.
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodPost {
+		http.Error(w, "Invalid method: expected GET or HEAD request", http.StatusMethodNotAllowed)
 		return
 	}
 
 	path := strings.TrimPrefix(r.URL.Path, "/")
 	paths := strings.Split(path, "/")
-	if len(paths) == 0 || paths[0] == "" || len(paths) > 2 {
+	if r.Method == http.MethodPost && (paths[0] == "" || isReportPath(paths)) {
+		http.Error(w, "Invalid method: expected GET or HEAD request", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(paths) == 0 || paths[0] == "" {
 		http.Error(w, "Invalid request URL", http.StatusBadRequest)
 		return
 	}
 
-	shortUrl, err := m.getShortUrlFromStore(paths[0])
+	shortUrl, err := m.getShortUrlFromStore(r.Context(), paths[0])
+
+	if err != nil && !errors.Is(err, errExpired) && !errors.Is(err, leveldb.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if shortUrl == nil {
+		// expired short urls are gone until the expiry scans move them to
+		// the expired namespace and for the grace period after that
+		m.lock.RLock()
+		expired := m.lookupExpired(paths[0])
+		if expired == nil && errors.Is(err, errExpired) {
+			expired = m.lookupShortUrl(paths[0])
+		}
+		m.lock.RUnlock()
+		if expired != nil {
+			// only redirects fall back, summary, stats and event requests
+			// get a plain error
+			if isRedirect(paths, expired) {
+				m.serveExpired(w, r, expired)
+				return
+			}
+			http.Error(w, "short url has expired", http.StatusGone)
+			return
+		}
+
+		if len(paths) == 1 {
+			m.serveNotFound(w, r, paths[0])
+			return
+		}
+		http.Error(w, "short url does not exist", http.StatusNotFound)
+		return
+	}
 
-	if err != nil || shortUrl == nil {
-		http.Error(w, "short url does not exist", http.StatusInternalServerError)
+	if state := shortUrl.GetDisabled(); state != nil {
+		// the short url's own fallback url is not used, its owner must not
+		// be able to keep a disabled short url redirecting
+		if isRedirect(paths, shortUrl) && m.redirectToFallback(w, r, nil, fallbackDisabled) {
+			return
+		}
+		m.serveTombstone(w, state)
 		return
 	}
 
 	// This is a normal short url request and not a summary request
-	if len(paths) == 1 {
-		m.AddCallToCacheAndDb(shortUrl)
-		http.Redirect(w, r, shortUrl.GetLongUrl(), http.StatusFound)
+	if isRedirect(paths, shortUrl) {
+		destination, err := forwardedDestination(r, shortUrl)
+		if err != nil {
+			http.Error(w, "Invalid request URL", http.StatusBadRequest)
+			return
+		}
+		if m.isBlocked(shortUrl) || m.isDestinationBlocked(shortUrl, destination) {
+			http.Error(w, "short url has been blocked", http.StatusForbidden)
+			return
+		}
+		if !urls.IsActive(shortUrl, time.Now()) {
+			m.servePending(w, r, shortUrl)
+			return
+		}
+		if shortUrl.GetOptions().Signed {
+			if err := m.verifyToken(r, shortUrl.GetId()); err != nil {
+				m.serveInvalidToken(w, err)
+				return
+			}
+		}
+		if shortUrl.GetOptions().PasswordHash == "" && r.Method == http.MethodPost {
+			http.Error(w, "Invalid method: expected GET or HEAD request", http.StatusMethodNotAllowed)
+			return
+		}
+		if shortUrl.GetOptions().PasswordHash != "" && !m.unlock(w, r, shortUrl) {
+			return
+		}
+		ok, last, err := m.claimClick(shortUrl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			m.serveClickLimit(w, r, shortUrl)
+			return
+		}
+		click := m.newClick(r)
+		m.recordClick(r.Context(), shortUrl, click)
+		m.streamClick(shortUrl, click)
+		http.Redirect(w, r, destination, http.StatusFound)
+		metrics.RedirectsTotal.Inc()
+		if last && shortUrl.GetOptions().DeleteAfterMaxClicks {
+			if err := m.moveToTrash(shortUrl.GetId()); err != nil {
+				m.logger.Error("handlers.go: failed to delete short url after its last click", zap.Error(err))
+			}
+		}
 		return
 	}
 
 	if len(paths) == 2 && paths[1] == "summary" {
-		io.WriteString(w, shortUrl.GetSummary())
+		io.WriteString(w, m.getSummary(shortUrl))
+		return
+	}
+
+	if len(paths) == 2 && paths[1] == "stats" {
+		m.serveStats(w, r, shortUrl)
+		return
+	}
+
+	if len(paths) == 2 && paths[1] == "events" {
+		// events carry the long url and options of the short url, so the
+		// stream is limited to the people who manage it like /admin/events
+		if !m.isOwner(r, shortUrl) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		m.serveEvents(w, r, shortUrl.GetId())
 		return
 	}
 
@@ -73,8 +196,34 @@ func (m *defaultUrlManager) GetUrlHandleFunc(w http.ResponseWriter, r *http.Requ
 }
 
 type createData struct {
-	Url    string `json:"url"`
+	Url string `json:"url"`
+	// a duration like "10d" or "never"
 	Expiry string `json:"expiry"`
+	// an RFC 3339 timestamp, instead of expiry
+	ExpiresAt string `json:"expires_at"`
+	// a duration like "30d" after which an unused short url expires
+	IdleTTL string `json:"idle_ttl"`
+	// how many times the short url can be resolved, 0 means no limit
+	MaxClicks int `json:"max_clicks"`
+	// move the short url to the trash after its last click
+	DeleteAfterMaxClicks bool `json:"delete_after_max_clicks"`
+	// an RFC 3339 timestamp before which the short url doesn't redirect
+	ActiveFrom string `json:"active_from"`
+	// where the short url redirects before active_from
+	PrelaunchUrl string `json:"prelaunch_url"`
+	// where the short url redirects once it expired or used up its clicks
+	FallbackUrl string `json:"fallback_url"`
+	// asked before redirecting, only its hash is stored
+	Password string `json:"password"`
+	// only redirect with an access token handed out by /sign
+	Signed bool `json:"signed"`
+	// append the path after the id to the url, e.g. /docs/v2 -> url + /v2
+	ForwardPath bool `json:"forward_path"`
+	// merge the query string of redirects into the url's
+	ForwardQuery bool `json:"forward_query"`
+	// "destination" or "request", which side wins for query parameters set
+	// on both
+	QueryPrecedence string `json:"query_precedence"`
 }
 
 func (m *defaultUrlManager) CreateUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
@@ -90,16 +239,135 @@ func (m *defaultUrlManager) CreateUrlHandleFunc(w http.ResponseWriter, r *http.R
 	}
 	var createData createData
 	json.Unmarshal(body, &createData)
-	expiry, err := time.ParseDuration(createData.Expiry)
+	longUrl, err := urls.Normalize(createData.Url, m.config.URL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = m.checkDestination(longUrl, r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	expiresAt, err := urls.ResolveExpiry(createData.Expiry, createData.ExpiresAt, time.Now(), m.config.Expiry)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	shortUrl, err := m.createShortUrl(createData.Url, expiry)
+	activeFrom, err := parseActiveFrom(createData.ActiveFrom, expiresAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	options, err := m.createOptions(createData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	options.Owner = ownerOf(r)
+	if options.PrelaunchUrl != "" && activeFrom.IsZero() {
+		http.Error(w, "prelaunch_url requires active_from", http.StatusBadRequest)
+		return
+	}
+	for _, destination := range []string{options.PrelaunchUrl, options.FallbackUrl} {
+		if destination == "" {
+			continue
+		}
+		err = m.checkDestination(destination, r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	shortUrl, err := m.createShortUrlWithOptions(longUrl, expiresAt, activeFrom, options)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	io.WriteString(w, fmt.Sprintf("Successfully created short url: http://localhost:3030/%s", shortUrl.GetId()))
 }
+
+// createOptions reads the optional settings of a create request.
+func (m *defaultUrlManager) createOptions(createData createData) (urls.Options, error) {
+	var options urls.Options
+
+	options.IdleTTL = time.Duration(m.config.Expiry.IdleTTL)
+	if createData.IdleTTL != "" {
+		ttl, err := urls.ParseDuration(createData.IdleTTL)
+		if err != nil {
+			return options, err
+		}
+		if ttl <= 0 {
+			return options, errors.New("handlers.go: idle_ttl must be positive")
+		}
+		options.IdleTTL = ttl
+	}
+
+	if createData.MaxClicks < 0 {
+		return options, errors.New("handlers.go: max_clicks must not be negative")
+	}
+	if createData.DeleteAfterMaxClicks && createData.MaxClicks == 0 {
+		return options, errors.New("handlers.go: delete_after_max_clicks requires max_clicks")
+	}
+	options.MaxClicks = createData.MaxClicks
+	options.DeleteAfterMaxClicks = createData.DeleteAfterMaxClicks
+
+	if createData.PrelaunchUrl != "" {
+		prelaunch, err := urls.Normalize(createData.PrelaunchUrl, m.config.URL)
+		if err != nil {
+			return options, err
+		}
+		options.PrelaunchUrl = prelaunch
+	}
+	if createData.FallbackUrl != "" {
+		fallback, err := urls.Normalize(createData.FallbackUrl, m.config.URL)
+		if err != nil {
+			return options, err
+		}
+		options.FallbackUrl = fallback
+	}
+	if createData.Password != "" {
+		hash, err := hashPassword(createData.Password)
+		if err != nil {
+			return options, err
+		}
+		options.PasswordHash = hash
+	}
+	if createData.Signed && len(m.config.Signing.Keys) == 0 {
+		return options, errors.New("handlers.go: signed short urls need signing keys in the config")
+	}
+	options.Signed = createData.Signed
+
+	switch createData.QueryPrecedence {
+	case "", urls.QueryPrecedenceDestination, urls.QueryPrecedenceRequest:
+	default:
+		return options, fmt.Errorf("handlers.go: query_precedence must be %q or %q", urls.QueryPrecedenceDestination, urls.QueryPrecedenceRequest)
+	}
+	if createData.QueryPrecedence != "" && !createData.ForwardQuery {
+		return options, errors.New("handlers.go: query_precedence requires forward_query")
+	}
+	options.ForwardPath = createData.ForwardPath
+	options.ForwardQuery = createData.ForwardQuery
+	options.QueryPrecedence = createData.QueryPrecedence
+	return options, nil
+}
+
+// parseActiveFrom reads the active_from of a create request, which has to be
+// before the short url expires. The zero time means the short url is active
+// right away.
+func parseActiveFrom(activeFrom string, expiresAt time.Time) (time.Time, error) {
+	if activeFrom == "" {
+		return time.Time{}, nil
+	}
+	at, err := time.Parse(time.RFC3339, activeFrom)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("handlers.go: invalid active_from %q, expected RFC 3339", activeFrom)
+	}
+	if !expiresAt.IsZero() && !at.Before(expiresAt) {
+		return time.Time{}, errors.New("handlers.go: active_from must be before the short url expires")
+	}
+	return at, nil
+}
@@ -2,10 +2,14 @@ package def
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +19,8 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
+	"github.com/moh-osman3/shortener/blocklist"
+	"github.com/moh-osman3/shortener/config"
 	"github.com/moh-osman3/shortener/urls"
 )
 
@@ -33,7 +39,7 @@ func TestDeleteUrlHandleFunc(t *testing.T) {
 		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
 	}
 	// test bad method
-	req, err := http.NewRequest(http.MethodGet, "/delete", nil)
+	req, err := http.NewRequest(http.MethodGet, "/delete", http.NoBody)
 	require.NoError(t, err)
 
 	w := httptest.NewRecorder()
@@ -61,7 +67,7 @@ func TestDeleteUrlHandleFunc(t *testing.T) {
 
 	testLongUrl := "www.testlongurl.com"
 	expiry := 5 * time.Minute
-	createdSurl, err := m.createShortUrl(testLongUrl, expiry)
+	createdSurl, err := m.createShortUrl(testLongUrl, time.Now().Add(expiry))
 	assert.NoError(t, err)
 	assert.NotNil(t, createdSurl)
 
@@ -83,7 +89,7 @@ func TestGetUrlHandleFunc(t *testing.T) {
 		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
 	}
 	// test bad method
-	req, err := http.NewRequest(http.MethodPost, "/", nil)
+	req, err := http.NewRequest(http.MethodPost, "/", http.NoBody)
 	require.NoError(t, err)
 
 	w := httptest.NewRecorder()
@@ -94,7 +100,7 @@ func TestGetUrlHandleFunc(t *testing.T) {
 	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
 
 	// test bad URL path too short
-	req, err = http.NewRequest(http.MethodGet, "/", nil)
+	req, err = http.NewRequest(http.MethodGet, "/", http.NoBody)
 	require.NoError(t, err)
 	req.URL.Path = ""
 
@@ -104,31 +110,31 @@ func TestGetUrlHandleFunc(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 
 	// test short url not found
-	req, err = http.NewRequest(http.MethodGet, "/testid", nil)
+	req, err = http.NewRequest(http.MethodGet, "/testid", http.NoBody)
 	require.NoError(t, err)
 
 	w = httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, http.StatusNotFound, w.Code)
 
-	// test bad URL path too long
-	req, err = http.NewRequest(http.MethodGet, "/test/path/too/long", nil)
+	// test path suffix of a short url that doesn't exist
+	req, err = http.NewRequest(http.MethodGet, "/test/path/too/long", http.NoBody)
 	require.NoError(t, err)
 
 	w = httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, http.StatusNotFound, w.Code)
 
 	testLongUrl := "www.testlongurl.com"
 	expiry := 5 * time.Minute
-	createdSurl, err := m.createShortUrl(testLongUrl, expiry)
+	createdSurl, err := m.createShortUrl(testLongUrl, time.Now().Add(expiry))
 	assert.NoError(t, err)
 	assert.NotNil(t, createdSurl)
 
 	// test happy path get shorurl
-	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s", createdSurl.GetId()), nil)
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s", createdSurl.GetId()), http.NoBody)
 	require.NoError(t, err)
 
 	w = httptest.NewRecorder()
@@ -136,21 +142,73 @@ func TestGetUrlHandleFunc(t *testing.T) {
 	assert.Equal(t, http.StatusFound, w.Code)
 
 	// test happy path summary
-	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s/summary", createdSurl.GetId()), nil)
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s/summary", createdSurl.GetId()), http.NoBody)
 	require.NoError(t, err)
 
 	w = httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, createdSurl.GetSummary(), w.Body.String())
+	assert.Equal(t, m.getSummary(createdSurl), w.Body.String())
+	assert.Contains(t, w.Body.String(), "total calls since creation: 1 calls")
 
 	// test len(paths) == 2 but path[1] is not "summary"
-	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s/brokensummary", createdSurl.GetId()), nil)
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s/brokensummary", createdSurl.GetId()), http.NoBody)
 	require.NoError(t, err)
 
 	w = httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// test blocking an existing short url without deleting it
+	rulesPath := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(rulesPath, []byte("link:"+createdSurl.GetId()+"\n"), 0o600))
+	m.blocklist, err = blocklist.New(rulesPath, false, zap.NewNop())
+	require.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/%s", createdSurl.GetId()), http.NoBody)
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	_, err = m.getShortUrlFromStore(context.Background(), createdSurl.GetId())
+	assert.NoError(t, err)
+}
+
+func TestCreateBlockedDestination(t *testing.T) {
+	rulesPath := filepath.Join(t.TempDir(), "blocklist.txt")
+	require.NoError(t, os.WriteFile(rulesPath, []byte("evil.com\n"), 0o600))
+	list, err := blocklist.New(rulesPath, false, zap.NewNop())
+	require.NoError(t, err)
+
+	m := &defaultUrlManager{
+		cache:     make(map[string]urls.ShortUrl),
+		logger:    zap.NewNop(),
+		leveldb:   NewMockDB(),
+		cipher:    feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+		blocklist: list,
+		config:    config.Config{OwnDomains: []string{"sho.rt"}},
+	}
+	handler := http.HandlerFunc(m.CreateUrlHandleFunc)
+
+	for _, dest := range []string{"https://login.evil.com", "https://sho.rt/MA==", "http://localhost:3030/MA=="} {
+		data := fmt.Sprintf("{\"url\":\"%s\",\"expiry\":\"10s\"}", dest)
+		req := httptest.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(data)))
+		req.Host = "localhost:3030"
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code, dest)
+	}
+
+	data := "{\"url\":\"https://example.com\",\"expiry\":\"10s\"}"
+	req := httptest.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(data)))
+	req.Host = "localhost:3030"
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
 }
 
 func TestCreateUrlHandleFunc(t *testing.T) {
@@ -161,7 +219,7 @@ func TestCreateUrlHandleFunc(t *testing.T) {
 		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
 	}
 	// test bad method
-	req, err := http.NewRequest(http.MethodGet, "/create", nil)
+	req, err := http.NewRequest(http.MethodGet, "/create", http.NoBody)
 	require.NoError(t, err)
 
 	w := httptest.NewRecorder()
@@ -188,6 +246,15 @@ func TestCreateUrlHandleFunc(t *testing.T) {
 	handler.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 
+	// test invalid destination url
+	badData = "{\"url\":\"javascript:alert(1)\",\"expiry\":\"10s\"}"
+	req, err = http.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(badData)))
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
 	// test error during create
 	data := "{\"url\":\"www.google.com\",\"expiry\":\"10s\"}"
 	// don't initialize cache to trigger internal error
@@ -211,4 +278,60 @@ func TestCreateUrlHandleFunc(t *testing.T) {
 	w = httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
 	assert.Equal(t, http.StatusOK, w.Code)
+
+	// the stored url is normalized
+	id := strings.TrimPrefix(w.Body.String(), "Successfully created short url: http://localhost:3030/")
+	surl, err := m.getShortUrlFromStore(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://www.google.com/", surl.GetLongUrl())
+}
+
+func TestCreateUrlExpiry(t *testing.T) {
+	m := &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+		config:  config.Config{Expiry: config.ExpiryConfig{Default: config.Duration(24 * time.Hour), Max: config.Duration(30 * 24 * time.Hour)}},
+	}
+	handler := http.HandlerFunc(m.CreateUrlHandleFunc)
+	create := func(data string) (int, urls.ShortUrl) {
+		req, err := http.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(data)))
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		id := strings.TrimPrefix(w.Body.String(), "Successfully created short url: http://localhost:3030/")
+		m.lock.RLock()
+		defer m.lock.RUnlock()
+		return w.Code, m.lookupShortUrl(id)
+	}
+
+	code, surl := create(`{"url":"https://example.com/days","expiry":"10d"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 10), surl.GetExpiry(), time.Minute)
+
+	expiresAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	code, surl = create(fmt.Sprintf(`{"url":"https://example.com/at","expires_at":"%s"}`, expiresAt.Format(time.RFC3339)))
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, expiresAt.Equal(surl.GetExpiry()))
+
+	code, surl = create(`{"url":"https://example.com/default"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), surl.GetExpiry(), time.Minute)
+
+	// never and anything past the maximum are rejected while a maximum is set
+	code, _ = create(`{"url":"https://example.com/never","expiry":"never"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = create(`{"url":"https://example.com/long","expiry":"5w"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = create(`{"url":"https://example.com/both","expiry":"1d","expires_at":"2099-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	m.config.Expiry.Max = 0
+	code, surl = create(`{"url":"https://example.com/never","expiry":"never"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, surl.GetExpiry().IsZero())
 }
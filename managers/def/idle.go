@@ -0,0 +1,61 @@
+package def
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/moh-osman3/shortener/stats"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+// the last time a short url with an idle ttl was resolved is kept under this
+// prefix as unix nanoseconds. It is written with the batched clicks, so it
+// lags behind redirects by up to the click flush interval.
+const accessPrefix = "access:"
+
+func accessKey(id string) []byte {
+	return []byte(accessPrefix + id)
+}
+
+// lastAccess returns the last time the short url was resolved, or the zero
+// time if that was never recorded.
+func (m *defaultUrlManager) lastAccess(id string) time.Time {
+	val, err := m.leveldb.Get(accessKey(id), nil)
+	if err != nil {
+		return time.Time{}
+	}
+	nanos, err := strconv.ParseInt(string(val), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// isIdle reports whether the short url hasn't been resolved within its idle
+// ttl.
+func (m *defaultUrlManager) isIdle(shortUrl urls.ShortUrl, now time.Time) bool {
+	if shortUrl.GetOptions().IdleTTL <= 0 {
+		return false
+	}
+	return now.After(urls.IdleExpiry(shortUrl, m.lastAccess(shortUrl.GetId())))
+}
+
+// recordAccess adds the time of the latest click by a person to the batch.
+// Crawlers and link previews don't keep a short url alive.
+func recordAccess(batch *leveldb.Batch, shortUrl urls.ShortUrl, clicks []stats.Click) {
+	if shortUrl.GetOptions().IdleTTL <= 0 {
+		return
+	}
+	var latest time.Time
+	for _, click := range clicks {
+		if !click.Bot && click.Time.After(latest) {
+			latest = click.Time
+		}
+	}
+	if latest.IsZero() {
+		return
+	}
+	batch.Put(accessKey(shortUrl.GetId()), []byte(strconv.FormatInt(latest.UnixNano(), 10)))
+}
@@ -0,0 +1,113 @@
+package def
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyrildever/feistel"
+	"github.com/cyrildever/feistel/common/utils/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/stats"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+func newIdleTestManager() *defaultUrlManager {
+	return &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+	}
+}
+
+// storeShortUrl puts a short url created at the given time in the cache and db.
+func storeShortUrl(t *testing.T, m *defaultUrlManager, id string, created time.Time, options urls.Options) urls.ShortUrl {
+	t.Helper()
+	shortUrl := urls.NewDefaultShortUrl(id, "https://www.testlongurl.com/", time.Time{}, created)
+	shortUrl.SetOptions(options)
+	record, err := shortUrl.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, m.leveldb.Put([]byte(id), record, nil))
+	m.cache[id] = shortUrl
+	return shortUrl
+}
+
+func TestIdleExpiry(t *testing.T) {
+	m := newIdleTestManager()
+	options := urls.Options{IdleTTL: time.Hour}
+
+	// never resolved, idle since its creation
+	storeShortUrl(t, m, "idle", time.Now().Add(-2*time.Hour), options)
+	// resolved recently
+	active := storeShortUrl(t, m, "active", time.Now().Add(-2*time.Hour), options)
+	m.recordClick(context.Background(), active, stats.Click{Time: time.Now().Add(-time.Minute)})
+	// no idle ttl
+	storeShortUrl(t, m, "plain", time.Now().Add(-2*time.Hour), urls.Options{})
+
+	_, err := m.getShortUrlFromStore(context.Background(), "idle")
+	assert.Error(t, err)
+	_, err = m.getShortUrlFromStore(context.Background(), "active")
+	assert.NoError(t, err)
+	_, err = m.getShortUrlFromStore(context.Background(), "plain")
+	assert.NoError(t, err)
+
+	m.scanAndDeleteCache()
+	assert.Nil(t, m.lookupShortUrl("idle"))
+	assert.NotNil(t, m.lookupShortUrl("active"))
+	assert.NotNil(t, m.lookupShortUrl("plain"))
+
+	// the db scan finds idle short urls that are not cached
+	delete(m.cache, "active")
+	require.NoError(t, m.leveldb.Put(accessKey("active"), []byte("0"), nil))
+	m.scanAndDeleteDb()
+	assert.Nil(t, m.lookupShortUrl("active"))
+	assert.NotNil(t, m.lookupExpired("active"))
+}
+
+func TestLastAccessRecordedWithClicks(t *testing.T) {
+	m := newIdleTestManager()
+	shortUrl := storeShortUrl(t, m, "idle", time.Now().Add(-2*time.Hour), urls.Options{IdleTTL: time.Hour})
+	assert.True(t, m.lastAccess("idle").IsZero())
+
+	clickTime := time.Now().Add(-time.Minute).Round(0)
+	m.recordClick(context.Background(), shortUrl, stats.Click{Time: clickTime})
+	assert.True(t, clickTime.Equal(m.lastAccess("idle")))
+
+	// bots don't keep a short url alive
+	m.recordClick(context.Background(), shortUrl, stats.Click{Time: time.Now(), Bot: true})
+	assert.True(t, clickTime.Equal(m.lastAccess("idle")))
+
+	// short urls without an idle ttl don't track their last access
+	plain := storeShortUrl(t, m, "plain", time.Now(), urls.Options{})
+	m.recordClick(context.Background(), plain, stats.Click{Time: time.Now()})
+	assert.True(t, m.lastAccess("plain").IsZero())
+}
+
+func TestCreateUrlIdleTTL(t *testing.T) {
+	m := newIdleTestManager()
+	handler := http.HandlerFunc(m.CreateUrlHandleFunc)
+
+	req, err := http.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(`{"url":"https://example.com","idle_ttl":"30d"}`)))
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	id := strings.TrimPrefix(w.Body.String(), "Successfully created short url: http://localhost:3030/")
+	assert.Equal(t, 30*24*time.Hour, m.lookupShortUrl(id).GetOptions().IdleTTL)
+
+	for _, bad := range []string{"soon", "-1d"} {
+		req, err = http.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(`{"url":"https://example.com","idle_ttl":"`+bad+`"}`)))
+		require.NoError(t, err)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, bad)
+	}
+}
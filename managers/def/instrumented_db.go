@@ -0,0 +1,63 @@
+package def
+
+import (
+	"errors"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"github.com/moh-osman3/shortener/metrics"
+)
+
+// instrumentedDB records latency and error metrics for every operation on
+// the wrapped DB.
+type instrumentedDB struct {
+	db DB
+}
+
+func newInstrumentedDB(db DB) DB {
+	return &instrumentedDB{db: db}
+}
+
+func observe(op string, start time.Time, err error) {
+	metrics.StoreOpDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	// a missing key is a normal result and not a store error
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		metrics.StoreErrorsTotal.WithLabelValues(op).Inc()
+	}
+}
+
+func (idb *instrumentedDB) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	start := time.Now()
+	val, err := idb.db.Get(key, ro)
+	observe("get", start, err)
+	return val, err
+}
+
+func (idb *instrumentedDB) Put(key, value []byte, wo *opt.WriteOptions) error {
+	start := time.Now()
+	err := idb.db.Put(key, value, wo)
+	observe("put", start, err)
+	return err
+}
+
+func (idb *instrumentedDB) Delete(key []byte, wo *opt.WriteOptions) error {
+	start := time.Now()
+	err := idb.db.Delete(key, wo)
+	observe("delete", start, err)
+	return err
+}
+
+func (idb *instrumentedDB) Write(batch *leveldb.Batch, wo *opt.WriteOptions) error {
+	start := time.Now()
+	err := idb.db.Write(batch, wo)
+	observe("write", start, err)
+	return err
+}
+
+func (idb *instrumentedDB) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	return idb.db.NewIterator(slice, ro)
+}
@@ -0,0 +1,29 @@
+package def
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moh-osman3/shortener/metrics"
+)
+
+func TestInstrumentedDB(t *testing.T) {
+	db := newInstrumentedDB(NewMockDB())
+	putErrors := testutil.ToFloat64(metrics.StoreErrorsTotal.WithLabelValues("put"))
+
+	assert.NoError(t, db.Put([]byte("key"), []byte("value"), nil))
+	val, err := db.Get([]byte("key"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// the mock db fails puts to the "error" key
+	assert.Error(t, db.Put([]byte("error"), []byte("value"), nil))
+	assert.Equal(t, putErrors+1, testutil.ToFloat64(metrics.StoreErrorsTotal.WithLabelValues("put")))
+
+	// one latency series for each of get, put and delete. Other tests in the
+	// package may have recorded batch writes as well.
+	assert.NoError(t, db.Delete([]byte("key"), nil))
+	assert.GreaterOrEqual(t, testutil.CollectAndCount(metrics.StoreOpDuration), 3)
+}
@@ -0,0 +1,58 @@
+package def
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb"
+
+	"github.com/moh-osman3/shortener/urls"
+)
+
+// the number of clicks used by a short url with max_clicks is kept under this
+// prefix. Unlike the click stats it is written synchronously on the redirect
+// path, so the limit holds across concurrent requests.
+const usesPrefix = "uses:"
+
+func usesKey(id string) []byte {
+	return []byte(usesPrefix + id)
+}
+
+// claimClick uses up one of the clicks of a short url with max_clicks. It
+// reports whether the redirect may go ahead, and whether it was the last click.
+// Short urls without a limit always go ahead without touching the db.
+func (m *defaultUrlManager) claimClick(shortUrl urls.ShortUrl) (ok bool, last bool, err error) {
+	max := shortUrl.GetOptions().MaxClicks
+	if max <= 0 {
+		return true, false, nil
+	}
+
+	m.usesLock.Lock()
+	defer m.usesLock.Unlock()
+
+	used, err := m.usedClicks(shortUrl.GetId())
+	if err != nil {
+		return false, false, err
+	}
+	if used >= max {
+		return false, false, nil
+	}
+	used++
+	err = m.leveldb.Put(usesKey(shortUrl.GetId()), []byte(strconv.Itoa(used)), nil)
+	if err != nil {
+		return false, false, err
+	}
+	return true, used == max, nil
+}
+
+// usedClicks returns how many clicks of a short url with max_clicks are used.
+func (m *defaultUrlManager) usedClicks(id string) (int, error) {
+	val, err := m.leveldb.Get(usesKey(id), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(val))
+}
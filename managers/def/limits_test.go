@@ -0,0 +1,148 @@
+package def
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moh-osman3/shortener/bots"
+)
+
+func createLimitedUrl(t *testing.T, m *defaultUrlManager, data string) string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(data)))
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	m.CreateUrlHandleFunc(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	return strings.TrimPrefix(w.Body.String(), "Successfully created short url: http://localhost:3030/")
+}
+
+// hammer resolves the short url from many goroutines at once and returns the
+// status codes it got back.
+func hammer(m *defaultUrlManager, id string, requests int) map[int]int {
+	var redirects, gone, other atomic.Int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			req := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+			w := httptest.NewRecorder()
+			m.GetUrlHandleFunc(w, req)
+			switch w.Code {
+			case http.StatusFound:
+				redirects.Add(1)
+			case http.StatusGone:
+				gone.Add(1)
+			default:
+				other.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return map[int]int{
+		http.StatusFound: int(redirects.Load()),
+		http.StatusGone:  int(gone.Load()),
+		0:                int(other.Load()),
+	}
+}
+
+func TestMaxClicksConcurrent(t *testing.T) {
+	m := newIdleTestManager()
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/download","max_clicks":5}`)
+
+	codes := hammer(m, id, 100)
+	assert.Equal(t, 5, codes[http.StatusFound])
+	assert.Equal(t, 95, codes[http.StatusGone])
+	assert.Equal(t, 0, codes[0])
+
+	used, err := m.usedClicks(id)
+	require.NoError(t, err)
+	assert.Equal(t, 5, used)
+
+	// summaries don't use up clicks
+	req := httptest.NewRequest(http.MethodGet, "/"+id+"/summary", nil)
+	w := httptest.NewRecorder()
+	m.GetUrlHandleFunc(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestOneTimeUrlDeletesItself(t *testing.T) {
+	m := newIdleTestManager()
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/secret","max_clicks":1,"delete_after_max_clicks":true}`)
+
+	codes := hammer(m, id, 50)
+	assert.Equal(t, 1, codes[http.StatusFound])
+
+	m.lock.RLock()
+	assert.Nil(t, m.lookupShortUrl(id))
+	m.lock.RUnlock()
+	_, err := m.leveldb.Get(trashKey(id), nil)
+	assert.NoError(t, err)
+
+	// restoring it from the trash doesn't give it new clicks
+	_, err = m.restoreFromTrash(id)
+	require.NoError(t, err)
+	codes = hammer(m, id, 5)
+	assert.Equal(t, 0, codes[http.StatusFound])
+	assert.Equal(t, 5, codes[http.StatusGone])
+}
+
+func TestMaxClicksCountHeadAndBots(t *testing.T) {
+	m := newIdleTestManager()
+	var err error
+	m.bots, err = bots.New("")
+	require.NoError(t, err)
+
+	get := func(id string, method string, userAgent string) int {
+		req := httptest.NewRequest(method, "/"+id, nil)
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		m.GetUrlHandleFunc(w, req)
+		return w.Code
+	}
+	const browser = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0"
+
+	// every redirect reveals the long url, so HEAD requests and link previews
+	// use up clicks too
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/download","max_clicks":1}`)
+	assert.Equal(t, http.StatusFound, get(id, http.MethodHead, browser))
+	assert.Equal(t, http.StatusGone, get(id, http.MethodGet, browser))
+	assert.Equal(t, http.StatusGone, get(id, http.MethodHead, browser))
+
+	id = createLimitedUrl(t, m, `{"url":"https://example.com/report","max_clicks":1}`)
+	assert.Equal(t, http.StatusFound, get(id, http.MethodGet, "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"))
+	assert.Equal(t, http.StatusGone, get(id, http.MethodGet, browser))
+}
+
+func TestCreateUrlMaxClicks(t *testing.T) {
+	m := newIdleTestManager()
+	id := createLimitedUrl(t, m, `{"url":"https://example.com","max_clicks":3}`)
+	m.lock.RLock()
+	options := m.lookupShortUrl(id).GetOptions()
+	m.lock.RUnlock()
+	assert.Equal(t, 3, options.MaxClicks)
+	assert.False(t, options.DeleteAfterMaxClicks)
+
+	for _, bad := range []string{
+		`{"url":"https://example.com","max_clicks":-1}`,
+		`{"url":"https://example.com","delete_after_max_clicks":true}`,
+	} {
+		req, err := http.NewRequest(http.MethodPost, "/create", bytes.NewBuffer([]byte(bad)))
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		m.CreateUrlHandleFunc(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, bad)
+	}
+}
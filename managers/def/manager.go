@@ -5,21 +5,51 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/cyrildever/feistel"
 	"github.com/cyrildever/feistel/common/utils/hash"
+	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 
+	"github.com/moh-osman3/shortener/blocklist"
+	"github.com/moh-osman3/shortener/bots"
+	"github.com/moh-osman3/shortener/config"
+	"github.com/moh-osman3/shortener/geo"
 	"github.com/moh-osman3/shortener/managers"
+	"github.com/moh-osman3/shortener/metrics"
+	"github.com/moh-osman3/shortener/ratelimit"
+	"github.com/moh-osman3/shortener/stats"
+	"github.com/moh-osman3/shortener/stream"
+	"github.com/moh-osman3/shortener/tracing"
 	"github.com/moh-osman3/shortener/urls"
+	"github.com/moh-osman3/shortener/webhooks"
 )
-const defaultObfuscationKeyLength = 32
+
+const (
+	defaultObfuscationKeyLength    = 32
+	defaultBlocklistReloadInterval = 30 * time.Second
+	// bounds how many taken sequence ids are skipped when creating a short url
+	maxGenerateAttempts = 100
+	// the next sequence id, kept so a restart doesn't walk through every id
+	// that was already issued
+	sequenceKey = "meta:sequence"
+)
+
+// errExpired is returned for short urls that passed their expiry or idle ttl
+// but haven't been deleted by the background scans yet.
+var errExpired = errors.New("managers.go: shortUrl expired")
 
 // this helps with testing with a mock db
 type DB interface {
@@ -27,81 +57,243 @@ type DB interface {
 	Put(key, value []byte, wo *opt.WriteOptions) error
 	Delete(key []byte, wo *opt.WriteOptions) error
 	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+	Write(batch *leveldb.Batch, wo *opt.WriteOptions) error
 }
 
 type defaultUrlManager struct {
-	cache      map[string]urls.ShortUrl
-	leveldb    DB
-	logger     *zap.Logger
-	lock       sync.RWMutex
+	cache   map[string]urls.ShortUrl
+	leveldb DB
+	logger  *zap.Logger
+	lock    sync.RWMutex
+	// serializes claimClick so max_clicks holds under concurrent redirects
+	usesLock sync.Mutex
+	// serializes the read-modify-write of click flushes with deleting stats,
+	// so flushes don't hold the manager lock through their db writes
+	statsLock  sync.Mutex
 	shutdownCh chan struct{}
 	numUrls    int
 	cipher     *feistel.FPECipher
+	config     config.Config
+	blocklist  *blocklist.List
+	geo        *geo.Reader
+	bots       *bots.Detector
+	// salt of the visitor hashes used to count unique visitors
+	visitorSalt []byte
+	clicks      chan clickEvent
+	clicksDone  chan struct{}
+	// nil when the manager is built without NewDefaultUrlManager, which turns
+	// webhooks off
+	webhooks     *webhooks.Dispatcher
+	webhooksDone chan struct{}
+	// live click and lifecycle events, nil like webhooks
+	stream *stream.Broker
+	// the configured not yet available page, nil for the built in one
+	pendingTemplate *template.Template
+	// the configured 404 and 410 pages, nil for the built in ones
+	notFoundTemplate *template.Template
+	goneTemplate     *template.Template
+	// signs the cookies of password protected short urls
+	cookieSecret []byte
+	// password attempts per short url
+	passwordAttempts *ratelimit.Limiter
 }
 
-func NewDefaultUrlManager(logger *zap.Logger, levelDb DB) managers.UrlManager {
-	return &defaultUrlManager{
+func NewDefaultUrlManager(logger *zap.Logger, levelDb DB, cfg *config.Config) managers.UrlManager {
+	m := &defaultUrlManager{
+		config:     *cfg,
 		cache:      make(map[string]urls.ShortUrl),
 		logger:     logger,
-		leveldb:    levelDb,
+		leveldb:    newInstrumentedDB(levelDb),
 		shutdownCh: make(chan struct{}, 1),
 		numUrls:    0,
 		cipher:     feistel.NewFPECipher(hash.SHA_256, newKey(defaultObfuscationKeyLength), 128),
 	}
+	m.webhooks = webhooks.New(m.leveldb, cfg.Webhooks, logger)
+	m.stream = stream.NewBroker(cfg.Stream.BufferSize, cfg.Stream.MaxSubscribers)
+	metrics.SetManagerGauges(m.cacheSize, m.sequenceValue)
+	return m
 }
 
-func (m *defaultUrlManager) deleteKeyFromCacheAndDb(key string) error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+func (m *defaultUrlManager) cacheSize() float64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return float64(len(m.cache))
+}
 
-	err1 := m.deleteShortUrlFromDb(key)
-	err2 := m.deleteShortUrlFromCache(key)
+func (m *defaultUrlManager) sequenceValue() float64 {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return float64(m.numUrls)
+}
 
-	// only return error if key does not exist in both cache and db
-	if err1 != nil && err2 != nil {
-		return errors.New("manager.go: deleting shorturl from cache that does not exist")
+// shouldExpire reports whether the background scans should delete the short
+// url, because it passed its expiry or sat idle for longer than its idle ttl.
+// Disabled short urls are kept as evidence even after they expire.
+func (m *defaultUrlManager) shouldExpire(shortUrl urls.ShortUrl) bool {
+	if shortUrl.GetDisabled() != nil {
+		return false
 	}
-	return nil
+	now := time.Now()
+	if !shortUrl.GetExpiry().IsZero() && now.After(shortUrl.GetExpiry()) {
+		return true
+	}
+	return m.isIdle(shortUrl, now)
 }
 
 func (m *defaultUrlManager) scanAndDeleteDb() {
+	start := time.Now()
+	defer func() {
+		metrics.SweepDuration.WithLabelValues("db").Observe(time.Since(start).Seconds())
+	}()
+
+	// collect expired keys first, deleting takes the write lock
+	var expired []string
 	m.lock.RLock()
-	defer m.lock.RUnlock()
 	iter := m.leveldb.NewIterator(nil, nil)
-	defer iter.Release()
-
 	for iter.Next() {
-		shortUrl := urls.NewDefaultShortUrl("", "", time.Second, time.Now())
+		if !isShortUrlKey(iter.Key()) {
+			continue
+		}
+		shortUrl := urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
 		shortUrl.Unmarshal([]byte(iter.Value()))
 
-		if !shortUrl.GetExpiry().IsZero() && time.Now().After(shortUrl.GetExpiry()) {
-			err := m.deleteKeyFromCacheAndDb(shortUrl.GetId())
-			if err != nil {
-				m.logger.Debug("error deleting key", zap.Error(err))
-			}
+		if m.shouldExpire(shortUrl) {
+			expired = append(expired, shortUrl.GetId())
 		}
 	}
+	iter.Release()
+	iterErr := iter.Error()
+	m.lock.RUnlock()
 
-	if iter.Error() != nil {
-		m.logger.Error("error retrieving redis db keys")
+	if iterErr != nil {
+		m.logger.Error("error retrieving leveldb keys", zap.Error(iterErr))
 		return
 	}
+
+	for _, id := range expired {
+		shortUrl, err := m.expireShortUrl(id)
+		if err != nil {
+			m.logger.Debug("error expiring key", zap.Error(err))
+			continue
+		}
+		if shortUrl == nil {
+			continue
+		}
+		metrics.SweepDeletionsTotal.WithLabelValues("db").Inc()
+		m.publish(webhooks.EventExpired, shortUrl)
+	}
 }
 
 func (m *defaultUrlManager) scanAndDeleteCache() {
-	for key, val := range m.cache {
-		if !val.GetExpiry().IsZero() && time.Now().After(val.GetExpiry()) {
-			err := m.deleteKeyFromCacheAndDb(key)
-			if err != nil {
-				m.logger.Debug("error deleting key", zap.Error(err))
-			}
+	start := time.Now()
+	defer func() {
+		metrics.SweepDuration.WithLabelValues("cache").Observe(time.Since(start).Seconds())
+	}()
+
+	var expired []string
+	m.lock.RLock()
+	for id, val := range m.cache {
+		if m.shouldExpire(val) {
+			expired = append(expired, id)
 		}
 	}
+	m.lock.RUnlock()
+
+	for _, id := range expired {
+		shortUrl, err := m.expireShortUrl(id)
+		if err != nil {
+			m.logger.Debug("error expiring key", zap.Error(err))
+			continue
+		}
+		if shortUrl == nil {
+			continue
+		}
+		metrics.SweepDeletionsTotal.WithLabelValues("cache").Inc()
+		m.publish(webhooks.EventExpired, shortUrl)
+	}
 }
 
 func (m *defaultUrlManager) Start(ctx context.Context, cacheInterval time.Duration, dbInterval time.Duration) error {
 	m.logger.Info("manager.go: starting url manager")
 
+	if m.config.Blocklist.Path != "" {
+		list, err := blocklist.New(m.config.Blocklist.Path, m.config.Blocklist.RequireAllow, m.logger)
+		if err != nil {
+			return err
+		}
+		m.blocklist = list
+
+		interval := time.Duration(m.config.Blocklist.ReloadInterval)
+		if interval <= 0 {
+			interval = defaultBlocklistReloadInterval
+		}
+		go m.blocklist.Watch(interval, m.shutdownCh)
+	}
+
+	if err := m.loadSequence(); err != nil {
+		return err
+	}
+
+	if err := m.loadVisitorSalt(); err != nil {
+		return err
+	}
+
+	if err := m.loadPendingTemplate(); err != nil {
+		return err
+	}
+
+	if err := m.loadFallbackPages(); err != nil {
+		return err
+	}
+
+	if err := m.loadPasswords(); err != nil {
+		return err
+	}
+
+	if err := checkSigningKeys(m.config.Signing.Keys); err != nil {
+		return err
+	}
+
+	if m.config.Bots.Enabled {
+		detector, err := bots.New(m.config.Bots.PatternsPath)
+		if err != nil {
+			return err
+		}
+		m.bots = detector
+	}
+
+	if m.config.Geo.DatabasePath != "" {
+		reader, err := geo.Open(m.config.Geo.DatabasePath)
+		if err != nil {
+			return err
+		}
+		m.geo = reader
+	}
+
+	queueSize := m.config.Clicks.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultClickQueueSize
+	}
+	flushInterval := time.Duration(m.config.Clicks.FlushInterval)
+	if flushInterval <= 0 {
+		flushInterval = defaultClickFlushInterval
+	}
+	batchSize := m.config.Clicks.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultClickBatchSize
+	}
+	m.clicks = make(chan clickEvent, queueSize)
+	m.clicksDone = make(chan struct{})
+	go m.runClickWorker(flushInterval, batchSize)
+
+	if m.webhooks != nil {
+		m.webhooksDone = make(chan struct{})
+		go func() {
+			defer close(m.webhooksDone)
+			m.webhooks.Run(m.shutdownCh)
+		}()
+	}
+
 	// todo: make interval configurable
 	cacheTicker := time.NewTicker(cacheInterval)
 
@@ -129,6 +321,9 @@ func (m *defaultUrlManager) Start(ctx context.Context, cacheInterval time.Durati
 				return
 			case <-dbTicker.C:
 				m.scanAndDeleteDb()
+				m.purgeTrash()
+				m.purgeExpired()
+				m.pruneStats()
 			}
 		}
 	}()
@@ -138,6 +333,22 @@ func (m *defaultUrlManager) Start(ctx context.Context, cacheInterval time.Durati
 func (m *defaultUrlManager) End() {
 	m.logger.Info("manager.go: shutting down url manager")
 	close(m.shutdownCh)
+	// ends the open event streams
+	if m.stream != nil {
+		m.stream.Close()
+	}
+
+	// wait for queued clicks to be written
+	if m.clicksDone != nil {
+		<-m.clicksDone
+	}
+	// let an in flight webhook delivery finish writing its result
+	if m.webhooksDone != nil {
+		<-m.webhooksDone
+	}
+	if m.geo != nil {
+		m.geo.Close()
+	}
 }
 
 func (m *defaultUrlManager) deleteShortUrlFromCache(key string) error {
@@ -162,51 +373,110 @@ func (m *defaultUrlManager) deleteShortUrlFromDb(key string) error {
 	return nil
 }
 
+func (m *defaultUrlManager) deleteStats(key string) {
+	m.statsLock.Lock()
+	defer m.statsLock.Unlock()
+
+	batch := new(leveldb.Batch)
+	// the last access time and used clicks go with the stats
+	batch.Delete(accessKey(key))
+	batch.Delete(usesKey(key))
+	err := stats.DeleteAll(m.leveldb, batch, key)
+	if err == nil {
+		err = m.leveldb.Write(batch, nil)
+	}
+	if err != nil {
+		m.logger.Error("manager.go: failed to delete stats", zap.Error(err))
+	}
+}
+
 func newKey(keyLength int) string {
 	buf := make([]byte, keyLength)
 	rand.Reader.Read(buf)
 	return string(buf)
 }
 
-func (m *defaultUrlManager) generateShortUrl(longUrl string, expiry time.Duration) urls.ShortUrl {
-	seqId := strconv.Itoa(m.numUrls)
-	obfuscated, err := m.cipher.EncryptString(seqId)
-	if err != nil {
-		m.logger.Error("Could not encrypt id using feistel cipher")
+// lookupShortUrl returns the short url stored under id in the cache or db, or
+// nil if the id is unused. The caller must hold the lock.
+func (m *defaultUrlManager) lookupShortUrl(id string) urls.ShortUrl {
+	if !isShortUrlId(id) {
 		return nil
 	}
-	hashStr := base64.URLEncoding.EncodeToString(obfuscated.Bytes())
-	shortUrl, ok := m.cache[hashStr]
+	if shortUrl, ok := m.cache[id]; ok {
+		return shortUrl
+	}
 
-	if ok {
-		if longUrl == shortUrl.GetLongUrl() {
-			return shortUrl
-		}
-		m.logger.Error("found corrupted shorturl")
+	val, err := m.leveldb.Get([]byte(id), nil)
+	if err != nil {
 		return nil
 	}
+	shortUrl := urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
+	shortUrl.Unmarshal([]byte(val))
+	return shortUrl
+}
 
-	// didn't find in cache so check db
-	val, err := m.leveldb.Get([]byte(hashStr), nil)
-	shortUrl = nil
-	if err == nil {
-		shortUrl = urls.NewDefaultShortUrl("", "", time.Second, time.Now())
-		shortUrl.Unmarshal([]byte(val))
+// copyShortUrl returns a copy of the short url to change. Requests read
+// cached short urls after releasing the lock, so they are replaced rather
+// than changed in place.
+func copyShortUrl(shortUrl urls.ShortUrl) (urls.ShortUrl, error) {
+	record, err := shortUrl.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	clone := urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
+	if err := clone.Unmarshal(record); err != nil {
+		return nil, err
 	}
+	return clone, nil
+}
 
-	if shortUrl != nil && shortUrl.GetLongUrl() != longUrl {
-		m.logger.Error("found corrupted shorturl")
-		return nil
-	} else if shortUrl != nil {
-		return shortUrl
+func (m *defaultUrlManager) generateShortUrl(longUrl string, expiresAt time.Time, activeFrom time.Time, options urls.Options) urls.ShortUrl {
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		seqId := strconv.Itoa(m.numUrls)
+		obfuscated, err := m.cipher.EncryptString(seqId)
+		if err != nil {
+			m.logger.Error("Could not encrypt id using feistel cipher")
+			return nil
+		}
+		hashStr := base64.URLEncoding.EncodeToString(obfuscated.Bytes())
+
+		shortUrl := m.lookupShortUrl(hashStr)
+		if shortUrl == nil && !m.isReserved(hashStr) {
+			shortUrl = urls.NewDefaultShortUrl(hashStr, longUrl, expiresAt, time.Now())
+			shortUrl.SetActiveFrom(activeFrom)
+			shortUrl.SetOptions(options)
+			return shortUrl
+		}
+		if shortUrl != nil && shortUrl.GetLongUrl() == longUrl && shortUrl.GetDisabled() == nil &&
+			shortUrl.GetActiveFrom().Equal(activeFrom) && shortUrl.GetOptions() == options {
+			return shortUrl
+		}
+
+		// the id belongs to another short url (possibly a disabled, deleted or
+		// expired one that must never be reissued) so move on to the next
+		// sequence id
+		m.logger.Debug("manager.go: skipping short url id that is already taken")
+		m.numUrls += 1
 	}
 
-	return urls.NewDefaultShortUrl(hashStr, longUrl, expiry, time.Now())
+	m.logger.Error("manager.go: no free short url id found")
+	return nil
+}
+
+// createShortUrl stores a short url for longUrl that expires at expiresAt, the
+// zero time meaning never.
+func (m *defaultUrlManager) createShortUrl(longUrl string, expiresAt time.Time) (urls.ShortUrl, error) {
+	return m.createShortUrlWithOptions(longUrl, expiresAt, time.Time{}, urls.Options{})
 }
 
-func (m *defaultUrlManager) createShortUrl(longUrl string, expiry time.Duration) (urls.ShortUrl, error) {
+// createShortUrlWithOptions stores a short url that only starts redirecting at
+// activeFrom, the zero time meaning right away, with the given options.
+func (m *defaultUrlManager) createShortUrlWithOptions(longUrl string, expiresAt time.Time, activeFrom time.Time, options urls.Options) (urls.ShortUrl, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	var shortUrl urls.ShortUrl
-	shortUrl = m.generateShortUrl(longUrl, expiry)
+	shortUrl = m.generateShortUrl(longUrl, expiresAt, activeFrom, options)
 
 	if shortUrl == nil {
 		m.logger.Error("unable to generate unique short url")
@@ -224,28 +494,66 @@ func (m *defaultUrlManager) createShortUrl(longUrl string, expiry time.Duration)
 		return nil, err
 	}
 
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	m.cache[shortUrl.GetId()] = shortUrl
-	err = m.leveldb.Put([]byte(shortUrl.GetId()), shortUrlStr, nil)
+	// generateShortUrl hands back the existing short url for a duplicate long url
+	created := m.lookupShortUrl(shortUrl.GetId()) == nil
+
 	m.numUrls += 1
+	batch := new(leveldb.Batch)
+	batch.Put([]byte(shortUrl.GetId()), shortUrlStr)
+	batch.Put([]byte(sequenceKey), []byte(strconv.Itoa(m.numUrls)))
+	m.cache[shortUrl.GetId()] = shortUrl
+	err = m.leveldb.Write(batch, nil)
 
+	if err == nil && created {
+		m.publish(webhooks.EventCreated, shortUrl)
+	}
 	return shortUrl, err
 }
 
-func (m *defaultUrlManager) getShortUrlFromStore(key string) (urls.ShortUrl, error) {
+// loadSequence restores the next sequence id from the db.
+func (m *defaultUrlManager) loadSequence() error {
+	val, err := m.leveldb.Get([]byte(sequenceKey), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	seq, err := strconv.Atoi(string(val))
+	if err != nil {
+		return fmt.Errorf("manager.go: invalid sequence %q: %w", val, err)
+	}
+	m.lock.Lock()
+	m.numUrls = seq
+	m.lock.Unlock()
+	return nil
+}
+
+func (m *defaultUrlManager) getShortUrlFromStore(ctx context.Context, key string) (urls.ShortUrl, error) {
+	if !isShortUrlId(key) {
+		return nil, leveldb.ErrNotFound
+	}
 	m.lock.RLock()
 	defer m.lock.RUnlock()
+
+	_, span := tracing.Tracer().Start(ctx, "cache.lookup")
 	shortUrl, ok := m.cache[key]
+	span.SetAttributes(attribute.Bool("cache.hit", ok))
+	span.End()
 
 	if ok {
+		metrics.CacheLookupsTotal.WithLabelValues("hit").Inc()
 		return m.isExpired(shortUrl)
 	}
+	metrics.CacheLookupsTotal.WithLabelValues("miss").Inc()
 
+	_, span = tracing.Tracer().Start(ctx, "store.get")
 	val, err := m.leveldb.Get([]byte(key), nil)
+	span.End()
+
 	shortUrl = nil
 	if err == nil {
-		shortUrl = urls.NewDefaultShortUrl("", "", time.Second, time.Now())
+		shortUrl = urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
 		shortUrl.Unmarshal([]byte(val))
 		return m.isExpired(shortUrl)
 	}
@@ -254,29 +562,73 @@ func (m *defaultUrlManager) getShortUrlFromStore(key string) (urls.ShortUrl, err
 }
 
 func (m *defaultUrlManager) isExpired(shortUrl urls.ShortUrl) (urls.ShortUrl, error) {
+	// disabled short urls are kept around to serve their tombstone page
+	if shortUrl.GetDisabled() != nil {
+		return shortUrl, nil
+	}
 	if !shortUrl.GetExpiry().IsZero() && time.Now().After(shortUrl.GetExpiry()) {
-		return nil, errors.New("managers.go: shortUrl expired")
+		return nil, errExpired
+	}
+	if m.isIdle(shortUrl, time.Now()) {
+		return nil, fmt.Errorf("%w after being idle", errExpired)
 	}
 	return shortUrl, nil
 }
 
-func (m *defaultUrlManager) AddCallToCacheAndDb(shortUrl urls.ShortUrl) {
-	m.lock.Lock()
-	defer m.lock.Unlock()
-	shortUrl.AddCall(time.Now())
-
-	// update cache with new value
-	m.cache[shortUrl.GetId()] = shortUrl
+// getCounts merges the counts from the stats keyspace with the counter that
+// records written before the stats keyspace carry. The caller must hold the lock.
+func (m *defaultUrlManager) getCounts(shortUrl urls.ShortUrl) stats.Counts {
+	counts, err := stats.GetCounts(m.leveldb, shortUrl.GetId(), time.Now())
+	if err != nil {
+		m.logger.Error("manager.go: failed to read stats", zap.Error(err))
+	}
+	if legacy := shortUrl.GetCounter(); legacy != nil {
+		day, week, total := legacy.Totals()
+		counts = counts.Add(stats.Counts{Day: day, Week: week, Total: total})
+	}
+	return counts
+}
 
-	shortUrlStr, err := shortUrl.Marshal()
+func (m *defaultUrlManager) getSummary(shortUrl urls.ShortUrl) string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.getCounts(shortUrl).String()
+}
 
+// checkDestination rejects destinations that are blocked or that point back at
+// the shortener itself, which would create a redirect loop.
+func (m *defaultUrlManager) checkDestination(longUrl string, requestHost string) error {
+	u, err := url.Parse(longUrl)
 	if err != nil {
-		m.logger.Error("manager.go: failed to save update shortUrl to db", zap.Error(err))
-		return
+		return err
 	}
+	host := strings.ToLower(u.Hostname())
 
-	err = m.leveldb.Put([]byte(shortUrl.GetId()), shortUrlStr, nil)
-	if err != nil {
-		m.logger.Error("manager.go: failed to save updated shortUrl to db", zap.Error(err))
+	ownDomains := m.config.OwnDomains
+	if requestHost != "" {
+		if h, _, err := net.SplitHostPort(requestHost); err == nil {
+			requestHost = h
+		}
+		ownDomains = append([]string{requestHost}, ownDomains...)
+	}
+	for _, domain := range ownDomains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return errors.New("manager.go: short urls can not point back at the shortener")
+		}
+	}
+
+	if m.blocklist != nil {
+		return m.blocklist.CheckUrl(longUrl)
+	}
+	return nil
+}
+
+// isBlocked reports whether redirects for an existing short url should be
+// refused because the link or its destination was added to the blocklist.
+func (m *defaultUrlManager) isBlocked(shortUrl urls.ShortUrl) bool {
+	if m.blocklist == nil {
+		return false
 	}
+	return m.blocklist.IsLinkBlocked(shortUrl.GetId()) || m.blocklist.CheckUrl(shortUrl.GetLongUrl()) != nil
 }
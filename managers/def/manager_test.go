@@ -3,22 +3,36 @@ package def
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/cyrildever/feistel"
 	"github.com/cyrildever/feistel/common/utils/hash"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/memdb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
 
+	"github.com/moh-osman3/shortener/stats"
 	"github.com/moh-osman3/shortener/urls"
 )
 
+// mockDB is shared with the background workers of the manager, so it is
+// guarded like leveldb is.
 type mockDB struct {
-	db map[string][]byte
+	lock sync.RWMutex
+	db   map[string][]byte
 }
 
 func NewMockDB() DB {
@@ -28,9 +42,11 @@ func NewMockDB() DB {
 }
 
 func (mdb *mockDB) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	mdb.lock.RLock()
+	defer mdb.lock.RUnlock()
 	val, ok := mdb.db[string(key)]
 	if !ok {
-		return []byte{}, errors.New("value not found in mockdb")
+		return []byte{}, fmt.Errorf("value not found in mockdb: %w", leveldb.ErrNotFound)
 	}
 
 	return val, nil
@@ -40,11 +56,15 @@ func (mdb *mockDB) Put(key, value []byte, wo *opt.WriteOptions) error {
 	if string(key) == "error" {
 		return errors.New("error during Put operation")
 	}
+	mdb.lock.Lock()
+	defer mdb.lock.Unlock()
 	mdb.db[string(key)] = value
 	return nil
 }
 
 func (mdb *mockDB) Delete(key []byte, wo *opt.WriteOptions) error {
+	mdb.lock.Lock()
+	defer mdb.lock.Unlock()
 	_, ok := mdb.db[string(key)]
 	if !ok {
 		return errors.New("deleting key that does not exist")
@@ -54,8 +74,33 @@ func (mdb *mockDB) Delete(key []byte, wo *opt.WriteOptions) error {
 	return nil
 }
 
+func (mdb *mockDB) Write(batch *leveldb.Batch, wo *opt.WriteOptions) error {
+	mdb.lock.Lock()
+	defer mdb.lock.Unlock()
+	return batch.Replay(&mockBatchReplay{mdb: mdb})
+}
+
+type mockBatchReplay struct {
+	mdb *mockDB
+}
+
+func (r *mockBatchReplay) Put(key, value []byte) {
+	r.mdb.db[string(key)] = value
+}
+
+func (r *mockBatchReplay) Delete(key []byte) {
+	delete(r.mdb.db, string(key))
+}
+
 func (mdb *mockDB) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
-	return iterator.NewEmptyIterator(nil)
+	// iterate over a sorted snapshot like leveldb does
+	mdb.lock.RLock()
+	defer mdb.lock.RUnlock()
+	snapshot := memdb.New(comparer.DefaultComparer, 0)
+	for key, val := range mdb.db {
+		snapshot.Put([]byte(key), val)
+	}
+	return snapshot.NewIterator(slice)
 }
 
 func TestCreateAndGetUrl(t *testing.T) {
@@ -68,14 +113,14 @@ func TestCreateAndGetUrl(t *testing.T) {
 
 	testLongUrl := "www.testlongurl.com"
 	expiry := 5 * time.Minute
-	createdSurl, err := defManager.createShortUrl(testLongUrl, expiry)
+	createdSurl, err := defManager.createShortUrl(testLongUrl, time.Now().Add(expiry))
 	assert.NoError(t, err)
 	assert.NotNil(t, createdSurl)
 	assert.Equal(t, testLongUrl, createdSurl.GetLongUrl())
 
 	expectedId := createdSurl.GetId()
 
-	fetchedSurl, err := defManager.getShortUrlFromStore(expectedId)
+	fetchedSurl, err := defManager.getShortUrlFromStore(context.Background(), expectedId)
 	assert.NoError(t, err)
 	assert.NotNil(t, fetchedSurl)
 	assert.Equal(t, expectedId, fetchedSurl.GetId())
@@ -90,7 +135,7 @@ func TestCreateAndGetUrl(t *testing.T) {
 
 	valStr, err := defManager.leveldb.Get([]byte(expectedId), nil)
 	assert.NoError(t, err)
-	surl := urls.NewDefaultShortUrl("", "", time.Second, time.Now())
+	surl := urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
 	surl.Unmarshal([]byte(valStr))
 	assert.Equal(t, createdSurl.GetLongUrl(), surl.GetLongUrl())
 	assert.Equal(t, createdSurl.GetExpiry().Unix(), surl.GetExpiry().Unix())
@@ -107,17 +152,19 @@ func TestDeleteUrl(t *testing.T) {
 
 	testLongUrl := "www.testlongurl.com"
 	expiry := 5 * time.Minute
-	createdSurl, err := defManager.createShortUrl(testLongUrl, expiry)
+	createdSurl, err := defManager.createShortUrl(testLongUrl, time.Now().Add(expiry))
 	assert.NoError(t, err)
 	assert.NotNil(t, createdSurl)
 	assert.Equal(t, testLongUrl, createdSurl.GetLongUrl())
 
 	expectedId := createdSurl.GetId()
 
-	err = defManager.deleteKeyFromCacheAndDb(expectedId)
+	err = defManager.moveToTrash(expectedId)
 	assert.NoError(t, err)
+	assert.NotNil(t, defManager.trashedShortUrl(expectedId))
+	assert.Error(t, defManager.moveToTrash(expectedId))
 
-	fetchedSurl, err := defManager.getShortUrlFromStore(expectedId)
+	fetchedSurl, err := defManager.getShortUrlFromStore(context.Background(), expectedId)
 	assert.Error(t, err)
 	assert.ErrorContains(t, err, "value not found")
 	assert.Nil(t, fetchedSurl)
@@ -142,36 +189,83 @@ func TestAddCallToShortUrl(t *testing.T) {
 
 	testLongUrl := "www.testlongurl.com"
 	expiry := 5 * time.Minute
-	createdSurl, err := defManager.createShortUrl(testLongUrl, expiry)
+	createdSurl, err := defManager.createShortUrl(testLongUrl, time.Now().Add(expiry))
 	assert.NoError(t, err)
 	assert.NotNil(t, createdSurl)
 	assert.Equal(t, testLongUrl, createdSurl.GetLongUrl())
 
 	expectedId := createdSurl.GetId()
 
-	startSummary := createdSurl.GetSummary()
+	startSummary := defManager.getSummary(createdSurl)
+	startRecord, err := defManager.leveldb.Get([]byte(expectedId), nil)
+	assert.NoError(t, err)
 
-	defManager.AddCallToCacheAndDb(createdSurl)
+	defManager.recordClick(context.Background(), createdSurl, stats.Click{Time: time.Now()})
 
-	fetchedSurl, err := defManager.getShortUrlFromStore(expectedId)
+	fetchedSurl, err := defManager.getShortUrlFromStore(context.Background(), expectedId)
 
 	assert.NoError(t, err)
 	assert.NotNil(t, fetchedSurl)
 	assert.Equal(t, expectedId, fetchedSurl.GetId())
-	assert.Equal(t, createdSurl.GetSummary(), fetchedSurl.GetSummary())
 
-	// confirm instrumentation persisted to cache and db
-	cacheSurl, ok := defManager.cache[expectedId]
-	assert.True(t, ok)
-	assert.NotEqual(t, startSummary, cacheSurl.GetSummary())
+	// confirm instrumentation persisted to the stats keyspace
+	summary := defManager.getSummary(fetchedSurl)
+	assert.NotEqual(t, startSummary, summary)
+	assert.Equal(t, stats.Counts{Day: 1, Week: 1, Total: 1}.String(), summary)
 
-	valStr, err := defManager.leveldb.Get([]byte(expectedId), nil)
+	// and that the short url record itself was not rewritten
+	record, err := defManager.leveldb.Get([]byte(expectedId), nil)
 	assert.NoError(t, err)
-	surl := urls.NewDefaultShortUrl("", "", time.Second, time.Now())
-	surl.Unmarshal([]byte(valStr))
-	assert.NotEqual(t, startSummary, surl.GetSummary())
+	assert.Equal(t, startRecord, record)
+}
+
+func TestLegacyCounterMerged(t *testing.T) {
+	defManager := &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+	}
+
+	// records written before the stats keyspace carry their own counter
+	legacy := urls.NewDefaultShortUrl("legacyid", "www.testlongurl.com", time.Now().Add(5*time.Minute), time.Now())
+	legacy.AddCall(time.Now())
+	legacy.AddCall(time.Now())
+	record, err := legacy.Marshal()
+	assert.NoError(t, err)
+	assert.NoError(t, defManager.leveldb.Put([]byte("legacyid"), record, nil))
 
-	assert.Equal(t, cacheSurl.GetSummary(), surl.GetSummary())
+	fetchedSurl, err := defManager.getShortUrlFromStore(context.Background(), "legacyid")
+	assert.NoError(t, err)
+	defManager.recordClick(context.Background(), fetchedSurl, stats.Click{Time: time.Now()})
+
+	assert.Equal(t, stats.Counts{Day: 3, Week: 3, Total: 3}.String(), defManager.getSummary(fetchedSurl))
+
+	// purging the deleted short url removes its stats
+	assert.NoError(t, defManager.moveToTrash("legacyid"))
+	defManager.config.TrashRetention = 1
+	defManager.purgeTrash()
+	counts, err := stats.GetCounts(defManager.leveldb, "legacyid", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, stats.Counts{}, counts)
+}
+
+func TestSequenceSurvivesRestart(t *testing.T) {
+	m := newIdleTestManager()
+	for i := 0; i < maxGenerateAttempts+50; i++ {
+		_, err := m.createShortUrl(fmt.Sprintf("https://example.com/%d", i), time.Time{})
+		require.NoError(t, err)
+	}
+
+	// a restarted manager carries on after the ids that were already issued
+	// rather than skipping them one by one from 0
+	restarted := newIdleTestManager()
+	restarted.leveldb = m.leveldb
+	require.NoError(t, restarted.loadSequence())
+	assert.Equal(t, m.numUrls, restarted.numUrls)
+	createdSurl, err := restarted.createShortUrl("https://example.com/new", time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/new", createdSurl.GetLongUrl())
 }
 
 func TestStartBackgroundCleanup(t *testing.T) {
@@ -185,14 +279,14 @@ func TestStartBackgroundCleanup(t *testing.T) {
 
 	testLongUrl := "www.testlongurl.com"
 	expiry1 := 5 * time.Minute
-	createdSurl, err := defManager.createShortUrl(testLongUrl, expiry1)
+	createdSurl, err := defManager.createShortUrl(testLongUrl, time.Now().Add(expiry1))
 	assert.NoError(t, err)
 	assert.NotNil(t, createdSurl)
 	assert.Equal(t, testLongUrl, createdSurl.GetLongUrl())
 
 	expiredUrl := "www.expiredurl.com"
 	expiry2 := 1 * time.Second
-	expiredSurl, err := defManager.createShortUrl(expiredUrl, expiry2)
+	expiredSurl, err := defManager.createShortUrl(expiredUrl, time.Now().Add(expiry2))
 	assert.NoError(t, err)
 	assert.NotNil(t, expiredSurl)
 	assert.Equal(t, expiredUrl, expiredSurl.GetLongUrl())
@@ -222,7 +316,7 @@ func TestStartBackgroundCleanup(t *testing.T) {
 
 	valStr, err := defManager.leveldb.Get([]byte(createdSurl.GetId()), nil)
 	assert.NoError(t, err)
-	surl := urls.NewDefaultShortUrl("", "", time.Second, time.Now())
+	surl := urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
 	surl.Unmarshal([]byte(valStr))
 	assert.Equal(t, createdSurl.GetLongUrl(), surl.GetLongUrl())
 	assert.Equal(t, createdSurl.GetExpiry().Unix(), surl.GetExpiry().Unix())
@@ -232,3 +326,39 @@ func TestStartBackgroundCleanup(t *testing.T) {
 	_, ok = <-defManager.shutdownCh
 	assert.False(t, ok)
 }
+
+func TestRedirectSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	defManager := &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+	}
+	createdSurl, err := defManager.createShortUrl("www.testlongurl.com", time.Now().Add(5*time.Minute))
+	assert.NoError(t, err)
+
+	// force a cache miss so the store is read
+	delete(defManager.cache, createdSurl.GetId())
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	fetchedSurl, err := defManager.getShortUrlFromStore(ctx, createdSurl.GetId())
+	assert.NoError(t, err)
+	defManager.recordClick(ctx, fetchedSurl, stats.Click{Time: time.Now()})
+	parent.End()
+
+	names := []string{}
+	for _, span := range recorder.Ended() {
+		names = append(names, span.Name())
+		assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID())
+	}
+	assert.Equal(t, []string{"cache.lookup", "store.get", "store.write", "counter.update", "parent"}, names)
+
+	for _, attr := range recorder.Ended()[0].Attributes() {
+		if attr.Key == "cache.hit" {
+			assert.False(t, attr.Value.AsBool())
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package def
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/moh-osman3/shortener/ratelimit"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+const (
+	// key of the cookies that unlock password protected short urls, generated
+	// on first start. Changing it locks everyone out again.
+	cookieSecretKey    = "meta:cookie_secret"
+	cookieSecretLength = 32
+
+	// one cookie per short url, scoped to its path since ids can't be part
+	// of a cookie name
+	passwordCookie = "shortener_pw"
+	// the form field of the password page
+	passwordField = "password"
+	// bcrypt only looks at the first 72 bytes
+	maxPasswordLength = 72
+	// bounds the size of the password form
+	maxPasswordFormSize = 4096
+
+	defaultPasswordCookieTTL   = time.Hour
+	defaultPasswordMaxAttempts = 5
+	defaultPasswordWindow      = 15 * time.Minute
+	passwordLimiterMaxKeys     = 10000
+)
+
+var passwordTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Password required</title></head>
+<body>
+<h1>Password required</h1>
+<p>This short url is protected by a password.</p>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<form method="post">
+<input type="password" name="password" autofocus required>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// hashPassword salts and hashes the password of a short url with bcrypt.
+func hashPassword(password string) (string, error) {
+	if len(password) > maxPasswordLength {
+		return "", errors.New("password.go: password must be at most 72 bytes")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// loadPasswords sets up the cookie secret and the per short url limit on
+// password attempts.
+func (m *defaultUrlManager) loadPasswords() error {
+	secret, err := m.leveldb.Get([]byte(cookieSecretKey), nil)
+	if err != nil && !errors.Is(err, leveldb.ErrNotFound) {
+		return err
+	}
+	if err != nil {
+		secret = make([]byte, cookieSecretLength)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		if err := m.leveldb.Put([]byte(cookieSecretKey), secret, nil); err != nil {
+			return err
+		}
+	}
+	m.cookieSecret = secret
+
+	maxAttempts := m.config.Passwords.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultPasswordMaxAttempts
+	}
+	window := time.Duration(m.config.Passwords.Window)
+	if window <= 0 {
+		window = defaultPasswordWindow
+	}
+	m.passwordAttempts = ratelimit.NewLimiter(float64(maxAttempts)/window.Seconds(), maxAttempts, passwordLimiterMaxKeys)
+	return nil
+}
+
+func (m *defaultUrlManager) passwordCookieTTL() time.Duration {
+	ttl := time.Duration(m.config.Passwords.CookieTTL)
+	if ttl <= 0 {
+		return defaultPasswordCookieTTL
+	}
+	return ttl
+}
+
+// cookieSignature signs the expiry of a cookie for a short url. The password
+// hash is part of it so changing the password invalidates handed out cookies.
+func (m *defaultUrlManager) cookieSignature(shortUrl urls.ShortUrl, expires string) []byte {
+	mac := hmac.New(sha256.New, m.cookieSecret)
+	mac.Write([]byte(shortUrl.GetId() + "." + expires + "." + shortUrl.GetOptions().PasswordHash))
+	return mac.Sum(nil)
+}
+
+// hasPasswordCookie reports whether the request carries an unexpired cookie
+// for the short url.
+func (m *defaultUrlManager) hasPasswordCookie(r *http.Request, shortUrl urls.ShortUrl) bool {
+	cookie, err := r.Cookie(passwordCookie)
+	if err != nil {
+		return false
+	}
+	expires, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(sig, m.cookieSignature(shortUrl, expires))
+}
+
+func (m *defaultUrlManager) setPasswordCookie(w http.ResponseWriter, r *http.Request, shortUrl urls.ShortUrl) {
+	ttl := m.passwordCookieTTL()
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     passwordCookie,
+		Value:    expires + "." + hex.EncodeToString(m.cookieSignature(shortUrl, expires)),
+		Path:     "/" + shortUrl.GetId(),
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// unlock checks the password of a protected short url. It reports whether
+// the redirect may go ahead, otherwise it has answered the request with the
+// password page. A correct password sets a cookie, so the password is only
+// asked once per cookie ttl.
+func (m *defaultUrlManager) unlock(w http.ResponseWriter, r *http.Request, shortUrl urls.ShortUrl) bool {
+	if m.hasPasswordCookie(r, shortUrl) {
+		return true
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		m.servePasswordPage(w, http.StatusOK, "")
+		return false
+	}
+
+	// every attempt takes a token, which also bounds the bcrypt work a
+	// single short url can cause
+	if ok, wait := m.passwordAttempts.Allow(shortUrl.GetId()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		m.servePasswordPage(w, http.StatusTooManyRequests, "Too many attempts, try again later.")
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxPasswordFormSize)
+	password := r.PostFormValue(passwordField)
+	err := bcrypt.CompareHashAndPassword([]byte(shortUrl.GetOptions().PasswordHash), []byte(password))
+	if err != nil {
+		m.servePasswordPage(w, http.StatusForbidden, "Incorrect password.")
+		return false
+	}
+	m.setPasswordCookie(w, r, shortUrl)
+	return true
+}
+
+func (m *defaultUrlManager) servePasswordPage(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	err := passwordTemplate.Execute(w, struct {
+		Error string
+	}{
+		Error: message,
+	})
+	if err != nil {
+		m.logger.Error("password.go: failed to render password page", zap.Error(err))
+	}
+}
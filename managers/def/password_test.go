@@ -0,0 +1,136 @@
+package def
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moh-osman3/shortener/config"
+)
+
+func newPasswordTestManager(t *testing.T) *defaultUrlManager {
+	m := newIdleTestManager()
+	m.config.Passwords = config.PasswordsConfig{MaxAttempts: 3, Window: config.Duration(time.Hour)}
+	require.NoError(t, m.loadPasswords())
+	return m
+}
+
+func postPassword(m *defaultUrlManager, id string, password string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	form := url.Values{passwordField: {password}}
+	req := httptest.NewRequest(http.MethodPost, "/"+id, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	m.GetUrlHandleFunc(w, req)
+	return w
+}
+
+func resolveWithCookie(m *defaultUrlManager, id string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	m.GetUrlHandleFunc(w, req)
+	return w
+}
+
+func TestPasswordProtectedUrl(t *testing.T) {
+	m := newPasswordTestManager(t)
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/internal","password":"hunter2"}`)
+	other := createLimitedUrl(t, m, `{"url":"https://example.com/other","password":"hunter2"}`)
+
+	// only a salted hash is stored
+	m.lock.RLock()
+	hash := m.lookupShortUrl(id).GetOptions().PasswordHash
+	m.lock.RUnlock()
+	assert.True(t, strings.HasPrefix(hash, "$2a$"))
+	assert.NotContains(t, hash, "hunter2")
+
+	w := resolve(m, id)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Password required")
+	assert.Empty(t, w.Header().Get("Location"))
+
+	w = postPassword(m, id, "wrong")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Incorrect password.")
+
+	w = postPassword(m, id, "hunter2")
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/internal", w.Header().Get("Location"))
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	cookie := cookies[0]
+	assert.Equal(t, "/"+id, cookie.Path)
+	assert.True(t, cookie.HttpOnly)
+
+	// the cookie unlocks the short url until it expires
+	w = resolveWithCookie(m, id, cookie)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/internal", w.Header().Get("Location"))
+
+	// but not other short urls, and it can't be changed
+	assert.Equal(t, http.StatusOK, resolveWithCookie(m, other, cookie).Code)
+	expires, _, _ := strings.Cut(cookie.Value, ".")
+	tampered := &http.Cookie{Name: passwordCookie, Value: expires + "." + strings.Repeat("0", 64)}
+	assert.Equal(t, http.StatusOK, resolveWithCookie(m, id, tampered).Code)
+	m.lock.RLock()
+	shortUrl := m.lookupShortUrl(id)
+	m.lock.RUnlock()
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	expired := &http.Cookie{Name: passwordCookie, Value: past + "." + hex.EncodeToString(m.cookieSignature(shortUrl, past))}
+	assert.Equal(t, http.StatusOK, resolveWithCookie(m, id, expired).Code)
+}
+
+func TestPasswordBruteForce(t *testing.T) {
+	m := newPasswordTestManager(t)
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/internal","password":"hunter2"}`)
+	other := createLimitedUrl(t, m, `{"url":"https://example.com/other","password":"hunter2"}`)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusForbidden, postPassword(m, id, "guess"+strconv.Itoa(i)).Code)
+	}
+	// even the right password is rejected once the attempts are used up
+	w := postPassword(m, id, "hunter2")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Empty(t, w.Result().Cookies())
+
+	// the limit is per short url
+	assert.Equal(t, http.StatusFound, postPassword(m, other, "hunter2").Code)
+}
+
+func TestPasswordCreateAndMethods(t *testing.T) {
+	m := newPasswordTestManager(t)
+	req, err := http.NewRequest(http.MethodPost, "/create", strings.NewReader(`{"url":"https://example.com","password":"`+strings.Repeat("x", 73)+`"}`))
+	require.NoError(t, err)
+	w := httptest.NewRecorder()
+	m.CreateUrlHandleFunc(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// only password protected short urls accept posts
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/open"}`)
+	assert.Equal(t, http.StatusMethodNotAllowed, postPassword(m, id, "hunter2").Code)
+	protected := createLimitedUrl(t, m, `{"url":"https://example.com/internal","password":"hunter2"}`)
+	req = httptest.NewRequest(http.MethodPost, "/"+protected+"/summary", nil)
+	w = httptest.NewRecorder()
+	m.GetUrlHandleFunc(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	// the hash is left out of webhook and event payloads
+	m.lock.RLock()
+	record, err := redactedRecord(m.lookupShortUrl(protected))
+	m.lock.RUnlock()
+	require.NoError(t, err)
+	assert.NotContains(t, string(record), "password_hash")
+	assert.Contains(t, string(record), "https://example.com/internal")
+}
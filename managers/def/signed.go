@@ -0,0 +1,219 @@
+package def
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/moh-osman3/shortener/config"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+const (
+	// query parameters of the access tokens of signed short urls
+	tokenExpiresParam   = "exp"
+	tokenKeyParam       = "kid"
+	tokenSignatureParam = "sig"
+
+	defaultTokenTTL = 24 * time.Hour
+	// signing secrets shorter than this are rejected on start
+	minSigningSecretLength = 16
+)
+
+var (
+	errTokenMissing = errors.New("signed.go: access token missing or invalid")
+	errTokenExpired = errors.New("signed.go: access token expired")
+)
+
+type signData struct {
+	Id string `json:"id"`
+	// a duration like "7d" the token is valid for, instead of expires_at
+	Expiry string `json:"expiry"`
+	// an RFC 3339 timestamp the token is valid until
+	ExpiresAt string `json:"expires_at"`
+}
+
+type signedUrlData struct {
+	Url       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// checkSigningKeys rejects signing keys without an id, with a short secret
+// or with an id used twice.
+func checkSigningKeys(keys []config.SigningKey) error {
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key.Id == "" {
+			return errors.New("signed.go: signing keys need an id")
+		}
+		if len(key.Secret) < minSigningSecretLength {
+			return fmt.Errorf("signed.go: secret of signing key %q must be at least %d bytes", key.Id, minSigningSecretLength)
+		}
+		if seen[key.Id] {
+			return fmt.Errorf("signed.go: signing key %q is configured twice", key.Id)
+		}
+		seen[key.Id] = true
+	}
+	return nil
+}
+
+// tokenSignature signs the id of a short url together with the unix time the
+// token expires.
+func tokenSignature(secret string, id string, expires string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id + "." + expires))
+	return mac.Sum(nil)
+}
+
+// signToken returns the query string of an access token for the short url
+// that expires at expiresAt, signed with the first configured key.
+func (m *defaultUrlManager) signToken(id string, expiresAt time.Time) (url.Values, error) {
+	if len(m.config.Signing.Keys) == 0 {
+		return nil, errors.New("signed.go: no signing keys configured")
+	}
+	key := m.config.Signing.Keys[0]
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return url.Values{
+		tokenExpiresParam:   {expires},
+		tokenKeyParam:       {key.Id},
+		tokenSignatureParam: {hex.EncodeToString(tokenSignature(key.Secret, id, expires))},
+	}, nil
+}
+
+// verifyToken checks the access token in the query string of a request for a
+// signed short url. Tokens without a key id are checked against every key.
+func (m *defaultUrlManager) verifyToken(r *http.Request, id string) error {
+	query := r.URL.Query()
+	expires := query.Get(tokenExpiresParam)
+	sig, err := hex.DecodeString(query.Get(tokenSignatureParam))
+	if expires == "" || err != nil || len(sig) == 0 {
+		return errTokenMissing
+	}
+
+	kid := query.Get(tokenKeyParam)
+	valid := false
+	for _, key := range m.config.Signing.Keys {
+		if kid != "" && key.Id != kid {
+			continue
+		}
+		if hmac.Equal(sig, tokenSignature(key.Secret, id, expires)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return errTokenMissing
+	}
+
+	// the expiry is only trusted once the signature checks out
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return errTokenMissing
+	}
+	if time.Now().Unix() > unix {
+		return errTokenExpired
+	}
+	return nil
+}
+
+// serveInvalidToken answers a redirect to a signed short url without a valid
+// access token.
+func (m *defaultUrlManager) serveInvalidToken(w http.ResponseWriter, err error) {
+	w.Header().Set("Cache-Control", "no-store")
+	if errors.Is(err, errTokenExpired) {
+		m.serveErrorPage(w, http.StatusGone, "This link has expired.", "")
+		return
+	}
+	m.serveErrorPage(w, http.StatusForbidden, "This link is missing a valid signature.", "")
+}
+
+// tokenExpiry reads when an access token should expire, defaulting to a day
+// from now.
+func tokenExpiry(signData signData, now time.Time) (time.Time, error) {
+	if signData.Expiry != "" && signData.ExpiresAt != "" {
+		return time.Time{}, errors.New("signed.go: set either expiry or expires_at, not both")
+	}
+	if signData.ExpiresAt != "" {
+		at, err := time.Parse(time.RFC3339, signData.ExpiresAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("signed.go: invalid expires_at %q, expected RFC 3339", signData.ExpiresAt)
+		}
+		if !at.After(now) {
+			return time.Time{}, errors.New("signed.go: expires_at must be in the future")
+		}
+		return at, nil
+	}
+	ttl := defaultTokenTTL
+	if signData.Expiry != "" {
+		d, err := urls.ParseDuration(signData.Expiry)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if d <= 0 {
+			return time.Time{}, errors.New("signed.go: expiry must be positive")
+		}
+		ttl = d
+	}
+	return now.Add(ttl), nil
+}
+
+// SignUrlHandleFunc hands out an access token for a signed short url at
+// /sign. Like renewing it is open to the owner of the short url and admins.
+func (m *defaultUrlManager) SignUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method: expected POST request", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	var signData signData
+	json.Unmarshal(body, &signData)
+	if !isShortUrlId(signData.Id) {
+		http.Error(w, "invalid short url id", http.StatusBadRequest)
+		return
+	}
+
+	m.lock.RLock()
+	shortUrl := m.lookupShortUrl(signData.Id)
+	m.lock.RUnlock()
+	if shortUrl == nil {
+		http.Error(w, "short url does not exist", http.StatusNotFound)
+		return
+	}
+	if !m.isOwner(r, shortUrl) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !shortUrl.GetOptions().Signed {
+		http.Error(w, "short url is not signed", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt, err := tokenExpiry(signData, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query, err := m.signToken(shortUrl.GetId(), expiresAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(signedUrlData{
+		Url:       fmt.Sprintf("http://localhost:3030/%s?%s", shortUrl.GetId(), query.Encode()),
+		ExpiresAt: time.Unix(expiresAt.Unix(), 0).UTC(),
+	})
+}
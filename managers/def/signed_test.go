@@ -0,0 +1,137 @@
+package def
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moh-osman3/shortener/config"
+	"github.com/moh-osman3/shortener/managers"
+)
+
+var (
+	testOldKey = config.SigningKey{Id: "2024", Secret: "old-secret-of-at-least-16-bytes"}
+	testNewKey = config.SigningKey{Id: "2025", Secret: "new-secret-of-at-least-16-bytes"}
+)
+
+func newSignedTestManager(t *testing.T, keys ...config.SigningKey) *defaultUrlManager {
+	m := newIdleTestManager()
+	m.config.AdminToken = testAdminToken
+	m.config.Signing.Keys = keys
+	require.NoError(t, checkSigningKeys(keys))
+	return m
+}
+
+// signUrl hands out an access token for the short url through /sign and
+// returns the path and query it redirects with.
+func signUrl(t *testing.T, m *defaultUrlManager, body string) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	m.SignUrlHandleFunc(w, newAdminRequest(t, http.MethodPost, "/sign", body))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var signed signedUrlData
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &signed))
+	u, err := url.Parse(signed.Url)
+	require.NoError(t, err)
+	return u.RequestURI()
+}
+
+func TestSignedUrl(t *testing.T) {
+	m := newSignedTestManager(t, testNewKey)
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/report","signed":true}`)
+
+	signed := signUrl(t, m, `{"id":"`+id+`","expiry":"1h"}`)
+	w := resolve(m, strings.TrimPrefix(signed, "/"))
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://example.com/report", w.Header().Get("Location"))
+
+	// without a token, with a changed expiry or for another short url it
+	// doesn't redirect
+	other := createLimitedUrl(t, m, `{"url":"https://example.com/other","signed":true}`)
+	query := strings.TrimPrefix(signed, "/"+id)
+	u, err := url.Parse(signed)
+	require.NoError(t, err)
+	values := u.Query()
+	values.Set(tokenExpiresParam, "99999999999")
+	for _, path := range []string{id, other + query, id + "?" + values.Encode()} {
+		w = resolve(m, path)
+		assert.Equal(t, http.StatusForbidden, w.Code, path)
+		assert.Empty(t, w.Header().Get("Location"))
+	}
+
+	expired, err := m.signToken(id, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	w = resolve(m, id+"?"+expired.Encode())
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Contains(t, w.Body.String(), "This link has expired.")
+}
+
+func TestSignedUrlKeyRotation(t *testing.T) {
+	m := newSignedTestManager(t, testOldKey)
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/report","signed":true}`)
+	old := signUrl(t, m, `{"id":"`+id+`"}`)
+
+	// tokens of the old key keep working after a new key is added in front
+	m.config.Signing.Keys = []config.SigningKey{testNewKey, testOldKey}
+	assert.Equal(t, http.StatusFound, resolve(m, strings.TrimPrefix(old, "/")).Code)
+	signed := signUrl(t, m, `{"id":"`+id+`"}`)
+	assert.Contains(t, signed, tokenKeyParam+"="+testNewKey.Id)
+
+	// until the old key is removed
+	m.config.Signing.Keys = []config.SigningKey{testNewKey}
+	assert.Equal(t, http.StatusForbidden, resolve(m, strings.TrimPrefix(old, "/")).Code)
+	assert.Equal(t, http.StatusFound, resolve(m, strings.TrimPrefix(signed, "/")).Code)
+}
+
+func TestSignUrl(t *testing.T) {
+	m := newSignedTestManager(t, testNewKey)
+	w := httptest.NewRecorder()
+	m.CreateUrlHandleFunc(w, newRenewRequest(t, "owner-key", `{"url":"https://example.com/report","signed":true}`))
+	require.Equal(t, http.StatusOK, w.Code)
+	id := strings.TrimPrefix(w.Body.String(), "Successfully created short url: http://localhost:3030/")
+	open := createLimitedUrl(t, m, `{"url":"https://example.com/open"}`)
+
+	for _, tc := range []struct {
+		apiKey string
+		body   string
+		code   int
+	}{
+		{"", `{"id":"` + id + `"}`, http.StatusForbidden},
+		{"other-key", `{"id":"` + id + `"}`, http.StatusForbidden},
+		{"owner-key", `{"id":"missing"}`, http.StatusNotFound},
+		{"owner-key", `{"id":"` + id + `","expiry":"1h","expires_at":"2030-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"owner-key", `{"id":"` + id + `","expires_at":"2000-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"owner-key", `{"id":"` + id + `","expiry":"7d"}`, http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodPost, "/sign", strings.NewReader(tc.body))
+		require.NoError(t, err)
+		if tc.apiKey != "" {
+			req.Header.Set(managers.ApiKeyHeader, tc.apiKey)
+		}
+		w = httptest.NewRecorder()
+		m.SignUrlHandleFunc(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.body)
+	}
+
+	w = httptest.NewRecorder()
+	m.SignUrlHandleFunc(w, newAdminRequest(t, http.MethodPost, "/sign", `{"id":"`+open+`"}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// signed short urls can't be created without signing keys
+	m.config.Signing.Keys = nil
+	req, err := http.NewRequest(http.MethodPost, "/create", strings.NewReader(`{"url":"https://example.com","signed":true}`))
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	m.CreateUrlHandleFunc(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Error(t, checkSigningKeys([]config.SigningKey{{Id: "short", Secret: "secret"}}))
+	assert.Error(t, checkSigningKeys([]config.SigningKey{testNewKey, testNewKey}))
+}
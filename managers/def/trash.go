@@ -0,0 +1,242 @@
+package def
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/urls"
+	"github.com/moh-osman3/shortener/webhooks"
+)
+
+// deleted short urls are moved under this prefix until they are restored or
+// purged. Short url ids are url safe base64 so they never contain a ':'.
+const trashPrefix = "trash:"
+
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+type trashEntry struct {
+	DeletedAt time.Time       `json:"deleted_at"`
+	ShortUrl  json.RawMessage `json:"short_url"`
+}
+
+type trashListItem struct {
+	Id        string    `json:"id"`
+	LongUrl   string    `json:"long_url"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Summary   string    `json:"summary"`
+}
+
+func trashKey(id string) []byte {
+	return []byte(trashPrefix + id)
+}
+
+// isShortUrlKey reports whether a db key holds a short url rather than an
+// entry in one of the prefixed namespaces.
+func isShortUrlKey(key []byte) bool {
+	return !strings.Contains(string(key), ":")
+}
+
+// isShortUrlId reports whether an id from a request can name a short url.
+// Ids that would reach one of the prefixed namespaces, e.g. "uses:<id>", are
+// rejected before they are used as a db key.
+func isShortUrlId(id string) bool {
+	return isShortUrlKey([]byte(id))
+}
+
+// moveToTrash removes a short url from the cache and the live keyspace and
+// keeps it in the trash namespace. Its stats are left in place so they are
+// still there if the short url is restored.
+func (m *defaultUrlManager) moveToTrash(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	shortUrl := m.lookupShortUrl(id)
+	if shortUrl == nil {
+		return errors.New("trash.go: deleting shorturl that does not exist")
+	}
+	record, err := shortUrl.Marshal()
+	if err != nil {
+		return err
+	}
+	entry, err := json.Marshal(trashEntry{DeletedAt: time.Now(), ShortUrl: record})
+	if err != nil {
+		return err
+	}
+
+	err = m.leveldb.Put(trashKey(id), entry, nil)
+	if err != nil {
+		return err
+	}
+	m.deleteShortUrlFromDb(id)
+	m.deleteShortUrlFromCache(id)
+	m.publish(webhooks.EventDeleted, shortUrl)
+	return nil
+}
+
+// trashedShortUrl returns the short url stored in the trash under id, or nil
+// if there is none.
+func (m *defaultUrlManager) trashedShortUrl(id string) urls.ShortUrl {
+	if !isShortUrlId(id) {
+		return nil
+	}
+	val, err := m.leveldb.Get(trashKey(id), nil)
+	if err != nil {
+		return nil
+	}
+	var entry trashEntry
+	if err := json.Unmarshal(val, &entry); err != nil {
+		return nil
+	}
+	shortUrl := urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
+	if err := shortUrl.Unmarshal(entry.ShortUrl); err != nil {
+		return nil
+	}
+	return shortUrl
+}
+
+func (m *defaultUrlManager) restoreFromTrash(id string) (urls.ShortUrl, error) {
+	if !isShortUrlId(id) {
+		return nil, errors.New("trash.go: short url is not in the trash")
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	val, err := m.leveldb.Get(trashKey(id), nil)
+	if err != nil {
+		return nil, errors.New("trash.go: short url is not in the trash")
+	}
+	var entry trashEntry
+	if err := json.Unmarshal(val, &entry); err != nil {
+		return nil, err
+	}
+
+	shortUrl := urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
+	if err := shortUrl.Unmarshal(entry.ShortUrl); err != nil {
+		return nil, err
+	}
+
+	err = m.leveldb.Put([]byte(id), entry.ShortUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	m.cache[id] = shortUrl
+	m.leveldb.Delete(trashKey(id), nil)
+	m.publish(webhooks.EventUpdated, shortUrl)
+	return shortUrl, nil
+}
+
+func (m *defaultUrlManager) listTrash() ([]trashListItem, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	items := []trashListItem{}
+	iter := m.leveldb.NewIterator(util.BytesPrefix([]byte(trashPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var entry trashEntry
+		if err := json.Unmarshal(iter.Value(), &entry); err != nil {
+			m.logger.Debug("trash.go: skipping unreadable trash entry", zap.Error(err))
+			continue
+		}
+		shortUrl := urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
+		shortUrl.Unmarshal(entry.ShortUrl)
+
+		items = append(items, trashListItem{
+			Id:        shortUrl.GetId(),
+			LongUrl:   shortUrl.GetLongUrl(),
+			DeletedAt: entry.DeletedAt,
+			Summary:   m.getCounts(shortUrl).String(),
+		})
+	}
+	return items, iter.Error()
+}
+
+// purgeTrash permanently deletes trash entries older than the retention period,
+// keeping only their retired marker.
+func (m *defaultUrlManager) purgeTrash() {
+	retention := time.Duration(m.config.TrashRetention)
+	if retention <= 0 {
+		retention = defaultTrashRetention
+	}
+
+	var purge [][]byte
+	m.lock.RLock()
+	iter := m.leveldb.NewIterator(util.BytesPrefix([]byte(trashPrefix)), nil)
+	for iter.Next() {
+		var entry trashEntry
+		if err := json.Unmarshal(iter.Value(), &entry); err != nil {
+			continue
+		}
+		if time.Since(entry.DeletedAt) > retention {
+			purge = append(purge, append([]byte{}, iter.Key()...))
+		}
+	}
+	iter.Release()
+	m.lock.RUnlock()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, key := range purge {
+		err := m.retire(key, strings.TrimPrefix(string(key), trashPrefix))
+		if err != nil {
+			m.logger.Debug("trash.go: error purging trash entry", zap.Error(err))
+		}
+	}
+}
+
+func (m *defaultUrlManager) ListTrashHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method: expected GET request", http.StatusMethodNotAllowed)
+		return
+	}
+	if !m.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	items, err := m.listTrash()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+func (m *defaultUrlManager) RestoreUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method: expected POST request", http.StatusMethodNotAllowed)
+		return
+	}
+	if !m.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	var restoreData deleteData
+	json.Unmarshal(body, &restoreData)
+	if !isShortUrlId(restoreData.Id) {
+		http.Error(w, "invalid short url id", http.StatusBadRequest)
+		return
+	}
+
+	_, err = m.restoreFromTrash(restoreData.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	m.logger.Info("trash.go: restored short url", zap.String("id", restoreData.Id))
+	io.WriteString(w, "Successfully restored short url!")
+}
@@ -0,0 +1,156 @@
+package def
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cyrildever/feistel"
+	"github.com/cyrildever/feistel/common/utils/hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/config"
+	"github.com/moh-osman3/shortener/stats"
+	"github.com/moh-osman3/shortener/urls"
+)
+
+func TestTrashAndRestore(t *testing.T) {
+	m := &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+		config:  config.Config{AdminToken: testAdminToken},
+	}
+	createdSurl, err := m.createShortUrl("www.testlongurl.com", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	id := createdSurl.GetId()
+	m.recordClick(context.Background(), createdSurl, stats.Click{Time: time.Now()})
+	summary := m.getSummary(createdSurl)
+
+	// delete through the public endpoint
+	w := httptest.NewRecorder()
+	req := newAdminRequest(t, http.MethodDelete, "/delete", fmt.Sprintf("{\"id\":\"%s\"}", id))
+	http.HandlerFunc(m.DeleteUrlHandleFunc).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, err = m.getShortUrlFromStore(context.Background(), id)
+	assert.Error(t, err)
+
+	// the id is not reissued while it is in the trash
+	m.numUrls = 0
+	newSurl, err := m.createShortUrl("www.otherurl.com", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	assert.NotEqual(t, id, newSurl.GetId())
+
+	// list the trash
+	w = httptest.NewRecorder()
+	http.HandlerFunc(m.ListTrashHandleFunc).ServeHTTP(w, newAdminRequest(t, http.MethodGet, "/admin/trash", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var items []trashListItem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+	require.Len(t, items, 1)
+	assert.Equal(t, id, items[0].Id)
+	assert.Equal(t, "www.testlongurl.com", items[0].LongUrl)
+	assert.Equal(t, summary, items[0].Summary)
+
+	// restoring requires the admin token
+	restore := http.HandlerFunc(m.RestoreUrlHandleFunc)
+	w = httptest.NewRecorder()
+	req = newAdminRequest(t, http.MethodPost, "/admin/restore", fmt.Sprintf("{\"id\":\"%s\"}", id))
+	req.Header.Del("Authorization")
+	restore.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	restore.ServeHTTP(w, newAdminRequest(t, http.MethodPost, "/admin/restore", fmt.Sprintf("{\"id\":\"%s\"}", id)))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// stats survive the round trip
+	restored, err := m.getShortUrlFromStore(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, summary, m.getSummary(restored))
+	assert.Contains(t, summary, "total calls since creation: 1 calls")
+
+	_, err = m.leveldb.Get(trashKey(id), nil)
+	assert.Error(t, err)
+
+	// restoring again fails because the trash is empty
+	w = httptest.NewRecorder()
+	restore.ServeHTTP(w, newAdminRequest(t, http.MethodPost, "/admin/restore", fmt.Sprintf("{\"id\":\"%s\"}", id)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestPurgeTrash(t *testing.T) {
+	m := &defaultUrlManager{
+		cache:   make(map[string]urls.ShortUrl),
+		logger:  zap.NewNop(),
+		leveldb: NewMockDB(),
+		cipher:  feistel.NewFPECipher(hash.SHA_256, "some-32-byte-long-key-to-be-safe", 128),
+		config:  config.Config{TrashRetention: config.Duration(time.Hour)},
+	}
+	oldSurl, err := m.createShortUrl("www.old.com", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	newSurl, err := m.createShortUrl("www.new.com", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+
+	require.NoError(t, m.moveToTrash(oldSurl.GetId()))
+	require.NoError(t, m.moveToTrash(newSurl.GetId()))
+
+	// pretend the first delete happened two hours ago
+	record, err := oldSurl.Marshal()
+	require.NoError(t, err)
+	entry, err := json.Marshal(trashEntry{DeletedAt: time.Now().Add(-2 * time.Hour), ShortUrl: record})
+	require.NoError(t, err)
+	require.NoError(t, m.leveldb.Put(trashKey(oldSurl.GetId()), entry, nil))
+
+	m.purgeTrash()
+
+	_, err = m.leveldb.Get(trashKey(oldSurl.GetId()), nil)
+	assert.Error(t, err)
+	_, err = m.leveldb.Get(trashKey(newSurl.GetId()), nil)
+	assert.NoError(t, err)
+
+	// the expiry scan ignores trash entries
+	m.scanAndDeleteDb()
+	_, err = m.leveldb.Get(trashKey(newSurl.GetId()), nil)
+	assert.NoError(t, err)
+}
+
+func TestInternalKeysAreNotIds(t *testing.T) {
+	m := newIdleTestManager()
+	m.config.AdminToken = testAdminToken
+	id := createLimitedUrl(t, m, `{"url":"https://example.com/secret","max_clicks":1}`)
+	assert.Equal(t, http.StatusFound, resolve(m, id).Code)
+
+	// deleting the used clicks of a one-time link would reset it
+	for _, key := range [][]byte{usesKey(id), []byte(sequenceKey)} {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"id":%q}`, key)
+		m.DeleteUrlHandleFunc(w, httptest.NewRequest(http.MethodDelete, "/delete", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, w.Code, string(key))
+		_, err := m.leveldb.Get(key, nil)
+		assert.NoError(t, err, string(key))
+	}
+	assert.Equal(t, http.StatusGone, resolve(m, id).Code)
+
+	// nor can they be read or renewed
+	assert.Equal(t, http.StatusNotFound, resolve(m, string(usesKey(id))).Code)
+	w := httptest.NewRecorder()
+	m.AdminGetUrlHandleFunc(w, newAdminRequest(t, http.MethodGet, "/admin/urls/"+sequenceKey, ""))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = httptest.NewRecorder()
+	m.RenewUrlHandleFunc(w, newAdminRequest(t, http.MethodPost, "/renew", fmt.Sprintf(`{"id":%q}`, retiredKey(id))))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	m.lock.RLock()
+	assert.Nil(t, m.lookupShortUrl(string(usesKey(id))))
+	m.lock.RUnlock()
+}
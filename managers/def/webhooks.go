@@ -0,0 +1,218 @@
+package def
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/stream"
+	"github.com/moh-osman3/shortener/urls"
+	"github.com/moh-osman3/shortener/webhooks"
+)
+
+const defaultDeliveriesLimit = 100
+
+type webhookData struct {
+	Id     string   `json:"id"`
+	Url    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+// linkEventData is the data of the link lifecycle events.
+type linkEventData struct {
+	Id       string          `json:"id"`
+	ShortUrl json.RawMessage `json:"short_url"`
+}
+
+// publish sends a lifecycle event to the event streams and queues it for the
+// webhook subscribers. Failures are logged, they never fail the change that
+// triggered the event.
+func (m *defaultUrlManager) publish(eventType string, shortUrl urls.ShortUrl) {
+	if m.webhooks == nil && m.stream == nil {
+		return
+	}
+	record, err := redactedRecord(shortUrl)
+	if err != nil {
+		m.logger.Error("webhooks.go: failed to marshal short url for webhook", zap.Error(err))
+		return
+	}
+	data := linkEventData{Id: shortUrl.GetId(), ShortUrl: record}
+
+	if m.stream != nil {
+		m.stream.Publish(stream.Event{Type: eventType, LinkID: data.Id, Time: time.Now(), Data: data})
+	}
+	if m.webhooks == nil {
+		return
+	}
+	err = m.webhooks.Publish(eventType, data)
+	if err != nil {
+		m.logger.Error("webhooks.go: failed to queue webhook event", zap.String("event", eventType), zap.Error(err))
+	}
+}
+
+// WebhooksHandleFunc lists (GET), creates (POST) and deletes (DELETE) webhook
+// subscriptions at /admin/webhooks. Secrets are only returned on creation.
+func (m *defaultUrlManager) WebhooksHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if !m.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if m.webhooks == nil {
+		http.Error(w, "webhooks are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		subs, err := m.webhooks.Subscriptions()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range subs {
+			subs[i].Secret = ""
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(subs)
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		var data webhookData
+		json.Unmarshal(body, &data)
+
+		sub, err := m.webhooks.CreateSubscription(data.Url, data.Events, data.Secret)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.logger.Info("webhooks.go: created webhook", zap.String("id", sub.ID), zap.String("url", sub.URL))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sub)
+	case http.MethodDelete:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		var data webhookData
+		json.Unmarshal(body, &data)
+
+		err = m.webhooks.DeleteSubscription(data.Id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		m.logger.Info("webhooks.go: deleted webhook", zap.String("id", data.Id))
+		io.WriteString(w, "Successfully deleted webhook!")
+	default:
+		http.Error(w, "Invalid method: expected GET, POST or DELETE request", http.StatusMethodNotAllowed)
+	}
+}
+
+// WebhookDeliveriesHandleFunc returns the delivery log, newest first, at
+// /admin/webhooks/deliveries. Use ?subscription=<id> to filter, ?limit=<n> to
+// bound the result and ?status=dead to list the dead letters instead.
+func (m *defaultUrlManager) WebhookDeliveriesHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid method: expected GET request", http.StatusMethodNotAllowed)
+		return
+	}
+	if !m.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if m.webhooks == nil {
+		http.Error(w, "webhooks are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	var result any
+	var err error
+	switch query.Get("status") {
+	case "":
+		limit := defaultDeliveriesLimit
+		if value := query.Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+		result, err = m.webhooks.Deliveries(query.Get("subscription"), limit)
+	case webhooks.OutcomeDead:
+		result, err = m.webhooks.DeadLetters()
+	default:
+		http.Error(w, "status must be empty or dead", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// RedeliverWebhookHandleFunc queues a dead letter again at
+// /admin/webhooks/redeliver.
+func (m *defaultUrlManager) RedeliverWebhookHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid method: expected POST request", http.StatusMethodNotAllowed)
+		return
+	}
+	if !m.isAdmin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if m.webhooks == nil {
+		http.Error(w, "webhooks are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	var data webhookData
+	json.Unmarshal(body, &data)
+
+	err = m.webhooks.Redeliver(data.Id)
+	if errors.Is(err, webhooks.ErrNotFound) {
+		http.Error(w, "delivery is not in the dead letters", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	m.logger.Info("webhooks.go: redelivering webhook", zap.String("id", data.Id))
+	io.WriteString(w, "Successfully queued webhook delivery!")
+}
+
+// redactedRecord marshals the short url without its password hash, which
+// must not leave the server.
+func redactedRecord(shortUrl urls.ShortUrl) ([]byte, error) {
+	record, err := shortUrl.Marshal()
+	if err != nil || shortUrl.GetOptions().PasswordHash == "" {
+		return record, err
+	}
+	redacted := urls.NewDefaultShortUrl("", "", time.Time{}, time.Now())
+	if err := redacted.Unmarshal(record); err != nil {
+		return nil, err
+	}
+	options := redacted.GetOptions()
+	options.PasswordHash = ""
+	redacted.SetOptions(options)
+	return redacted.Marshal()
+}
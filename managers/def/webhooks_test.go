@@ -0,0 +1,172 @@
+package def
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/config"
+	"github.com/moh-osman3/shortener/urls"
+	"github.com/moh-osman3/shortener/webhooks"
+)
+
+type webhookReceiver struct {
+	lock   sync.Mutex
+	secret string
+	events []webhooks.Event
+	errors []error
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if err := webhooks.Verify(rc.secret, r.Header.Get(webhooks.SignatureHeader), body, time.Now(), time.Minute); err != nil {
+		rc.errors = append(rc.errors, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var event webhooks.Event
+	json.Unmarshal(body, &event)
+	rc.events = append(rc.events, event)
+}
+
+func (rc *webhookReceiver) types() []string {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	var types []string
+	for _, event := range rc.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestWebhookLifecycleEvents(t *testing.T) {
+	rc := &webhookReceiver{secret: "secret"}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.AdminToken = testAdminToken
+	cfg.Webhooks.PollInterval = config.Duration(10 * time.Millisecond)
+	m := NewDefaultUrlManager(zap.NewNop(), NewMockDB(), cfg).(*defaultUrlManager)
+	require.NoError(t, m.Start(context.Background(), time.Hour, time.Hour))
+	defer m.End()
+
+	w := httptest.NewRecorder()
+	req := newAdminRequest(t, http.MethodPost, "/admin/webhooks", fmt.Sprintf(`{"url":"%s","secret":"secret"}`, server.URL))
+	http.HandlerFunc(m.WebhooksHandleFunc).ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	createdSurl, err := m.createShortUrl("https://www.testlongurl.com/", time.Now().Add(5*time.Minute))
+	require.NoError(t, err)
+	id := createdSurl.GetId()
+
+	require.NoError(t, m.setDisabled(id, &urls.DisabledState{Reason: "abuse", Timestamp: time.Now()}))
+	require.NoError(t, m.setDisabled(id, nil))
+	require.NoError(t, m.moveToTrash(id))
+	_, err = m.restoreFromTrash(id)
+	require.NoError(t, err)
+
+	expiring, err := m.createShortUrl("https://www.expiring.com/", time.Now().Add(time.Millisecond))
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	m.scanAndDeleteCache()
+
+	expected := []string{
+		webhooks.EventCreated,
+		webhooks.EventDisabled,
+		webhooks.EventUpdated,
+		webhooks.EventDeleted,
+		webhooks.EventUpdated,
+		webhooks.EventCreated,
+		webhooks.EventExpired,
+	}
+	assert.Eventually(t, func() bool {
+		return len(rc.types()) == len(expected)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, expected, rc.types())
+	assert.Empty(t, rc.errors)
+
+	rc.lock.Lock()
+	var expired linkEventData
+	for _, event := range rc.events {
+		if event.Type == webhooks.EventExpired {
+			require.NoError(t, json.Unmarshal(event.Data, &expired))
+		}
+	}
+	rc.lock.Unlock()
+	assert.Equal(t, expiring.GetId(), expired.Id)
+
+	// every attempt is in the delivery log
+	w = httptest.NewRecorder()
+	req = newAdminRequest(t, http.MethodGet, "/admin/webhooks/deliveries?limit=2", "")
+	http.HandlerFunc(m.WebhookDeliveriesHandleFunc).ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var entries []webhooks.LogEntry
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+	assert.Len(t, entries, 2)
+	assert.Equal(t, webhooks.OutcomeDelivered, entries[0].Outcome)
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	cfg := config.Default()
+	cfg.AdminToken = testAdminToken
+	m := NewDefaultUrlManager(zap.NewNop(), NewMockDB(), cfg).(*defaultUrlManager)
+	handler := http.HandlerFunc(m.WebhooksHandleFunc)
+
+	// admin only
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/admin/webhooks", http.NoBody)
+	require.NoError(t, err)
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newAdminRequest(t, http.MethodPost, "/admin/webhooks", `{"url":"not a url"}`))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newAdminRequest(t, http.MethodPost, "/admin/webhooks", `{"url":"https://example.com/hook","events":["link.deleted"]}`))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created webhooks.Subscription
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.Secret)
+
+	// secrets are not listed
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newAdminRequest(t, http.MethodGet, "/admin/webhooks", ""))
+	require.Equal(t, http.StatusOK, w.Code)
+	var subs []webhooks.Subscription
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &subs))
+	require.Len(t, subs, 1)
+	assert.Equal(t, created.ID, subs[0].ID)
+	assert.Empty(t, subs[0].Secret)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newAdminRequest(t, http.MethodDelete, "/admin/webhooks", fmt.Sprintf(`{"id":"%s"}`, created.ID)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newAdminRequest(t, http.MethodDelete, "/admin/webhooks", fmt.Sprintf(`{"id":"%s"}`, created.ID)))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	http.HandlerFunc(m.RedeliverWebhookHandleFunc).ServeHTTP(w, newAdminRequest(t, http.MethodPost, "/admin/webhooks/redeliver", `{"id":"missing"}`))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	http.HandlerFunc(m.WebhookDeliveriesHandleFunc).ServeHTTP(w, newAdminRequest(t, http.MethodGet, "/admin/webhooks/deliveries?status=dead", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+}
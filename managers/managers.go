@@ -6,10 +6,25 @@ import (
 	"time"
 )
 
+// ApiKeyHeader carries the api key of a request. It identifies the owner of a
+// short url and keys the per api key limits of /create.
+const ApiKeyHeader = "X-API-Key"
+
 type UrlManager interface {
 	CreateUrlHandleFunc(w http.ResponseWriter, r *http.Request)
 	DeleteUrlHandleFunc(w http.ResponseWriter, r *http.Request)
 	GetUrlHandleFunc(w http.ResponseWriter, r *http.Request)
+	DisableUrlHandleFunc(w http.ResponseWriter, r *http.Request)
+	EnableUrlHandleFunc(w http.ResponseWriter, r *http.Request)
+	AdminGetUrlHandleFunc(w http.ResponseWriter, r *http.Request)
+	ListTrashHandleFunc(w http.ResponseWriter, r *http.Request)
+	RestoreUrlHandleFunc(w http.ResponseWriter, r *http.Request)
+	RenewUrlHandleFunc(w http.ResponseWriter, r *http.Request)
+	SignUrlHandleFunc(w http.ResponseWriter, r *http.Request)
+	WebhooksHandleFunc(w http.ResponseWriter, r *http.Request)
+	WebhookDeliveriesHandleFunc(w http.ResponseWriter, r *http.Request)
+	RedeliverWebhookHandleFunc(w http.ResponseWriter, r *http.Request)
+	EventsHandleFunc(w http.ResponseWriter, r *http.Request)
 	Start(ctx context.Context, cacheInterval time.Duration, dbInterval time.Duration) error
 	End()
 }
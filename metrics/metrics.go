@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "shortener"
+
+var (
+	Registry = prometheus.NewRegistry()
+
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of http requests by route and status code.",
+	}, []string{"route", "code"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of http requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+
+	RedirectsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Number of successful redirects. Use rate() for redirects per second.",
+	})
+
+	FallbackRedirectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fallback_redirects_total",
+		Help:      "Number of redirects to a fallback url by reason (expired, disabled, click_limit or not_found).",
+	}, []string{"reason"})
+
+	CacheLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Number of short url cache lookups by result (hit or miss).",
+	}, []string{"result"})
+
+	StoreOpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Latency of leveldb operations by operation.",
+		Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1},
+	}, []string{"op"})
+
+	StoreErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_errors_total",
+		Help:      "Number of failed leveldb operations by operation.",
+	}, []string{"op"})
+
+	ClicksDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "clicks_dropped_total",
+		Help:      "Number of clicks dropped because the click queue was full.",
+	})
+
+	StreamSubscribers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stream_subscribers",
+		Help:      "Number of open event streams.",
+	})
+
+	StreamDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_subscribers_dropped_total",
+		Help:      "Number of event streams disconnected because they fell behind.",
+	})
+
+	SweepDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "expiry_sweep_duration_seconds",
+		Help:      "Duration of the background expiry scans by store (cache or db).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"store"})
+
+	SweepDeletionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "expiry_sweep_deletions_total",
+		Help:      "Number of expired short urls deleted by the background scans by store (cache or db).",
+	}, []string{"store"})
+
+	CacheSize = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_size",
+		Help:      "Number of short urls held in the in memory cache.",
+	}, func() float64 { return callGauge(&cacheSizeFn) })
+
+	SequenceValue = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sequence_value",
+		Help:      "Current value of the sequence counter used to generate short url ids.",
+	}, func() float64 { return callGauge(&sequenceFn) })
+
+	gaugeLock   sync.RWMutex
+	cacheSizeFn func() float64
+	sequenceFn  func() float64
+)
+
+func init() {
+	Registry.MustRegister(
+		RequestsTotal,
+		RequestDuration,
+		RedirectsTotal,
+		FallbackRedirectsTotal,
+		ClicksDroppedTotal,
+		StreamSubscribers,
+		StreamDroppedTotal,
+		CacheLookupsTotal,
+		StoreOpDuration,
+		StoreErrorsTotal,
+		SweepDuration,
+		SweepDeletionsTotal,
+		CacheSize,
+		SequenceValue,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// SetManagerGauges sets the callbacks used to read the cache size and the
+// sequence counter of the running url manager when metrics are scraped.
+func SetManagerGauges(cacheSize func() float64, sequence func() float64) {
+	gaugeLock.Lock()
+	defer gaugeLock.Unlock()
+	cacheSizeFn = cacheSize
+	sequenceFn = sequence
+}
+
+func callGauge(fn *func() float64) float64 {
+	gaugeLock.RLock()
+	defer gaugeLock.RUnlock()
+	if *fn == nil {
+		return 0
+	}
+	return (*fn)()
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
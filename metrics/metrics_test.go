@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagerGauges(t *testing.T) {
+	// gauges read 0 until a manager registers its callbacks
+	SetManagerGauges(nil, nil)
+	assert.Equal(t, float64(0), testutil.ToFloat64(CacheSize))
+
+	SetManagerGauges(func() float64 { return 3 }, func() float64 { return 42 })
+	assert.Equal(t, float64(3), testutil.ToFloat64(CacheSize))
+	assert.Equal(t, float64(42), testutil.ToFloat64(SequenceValue))
+}
+
+func TestHandler(t *testing.T) {
+	RedirectsTotal.Inc()
+
+	server := httptest.NewServer(Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "shortener_redirects_total")
+	assert.Contains(t, string(body), "shortener_cache_size")
+	assert.Contains(t, string(body), "go_goroutines")
+}
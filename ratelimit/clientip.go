@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/moh-osman3/shortener/config"
+)
+
+// ClientIP returns the ip of the client that sent the request, the key of the
+// per ip limits. With TrustProxyHeaders it is read from X-Forwarded-For, where
+// each trusted proxy appends the address it was connected from. Only the
+// entries added by the trusted hops are used, the ones left of them are sent
+// by the client and can be anything. Requests with fewer entries than trusted
+// hops didn't come through all the proxies and fall back to the address of
+// the connection.
+func ClientIP(r *http.Request, cfg config.RateLimitConfig) string {
+	if cfg.TrustProxyHeaders {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			entries := strings.Split(strings.Join(fwd, ","), ",")
+			hops := cfg.TrustedHops
+			if hops <= 0 {
+				hops = 1
+			}
+			// with fewer entries than hops the leftmost one may come from the
+			// client
+			if i := len(entries) - hops; i >= 0 {
+				if ip := strings.TrimSpace(entries[i]); ip != "" {
+					return ip
+				}
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/moh-osman3/shortener/config"
+)
+
+func TestClientIP(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 1.2.3.4")
+
+	cfg := config.RateLimitConfig{}
+	assert.Equal(t, "10.0.0.1", ClientIP(req, cfg))
+
+	// the client can prepend anything, only the entry of the proxy counts
+	cfg.TrustProxyHeaders = true
+	assert.Equal(t, "1.2.3.4", ClientIP(req, cfg))
+	cfg.TrustedHops = 1
+	assert.Equal(t, "1.2.3.4", ClientIP(req, cfg))
+
+	// behind two proxies the second one appends the first one's address
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 1.2.3.4, 10.0.0.2")
+	cfg.TrustedHops = 2
+	assert.Equal(t, "1.2.3.4", ClientIP(req, cfg))
+
+	// with fewer entries than hops the client may have set every one of them
+	cfg.TrustedHops = 4
+	assert.Equal(t, "10.0.0.1", ClientIP(req, cfg))
+
+	// entries of repeated headers are read in order
+	req.Header.Set("X-Forwarded-For", "6.6.6.6")
+	req.Header.Add("X-Forwarded-For", "1.2.3.4")
+	cfg.TrustedHops = 1
+	assert.Equal(t, "1.2.3.4", ClientIP(req, cfg))
+
+	req.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "10.0.0.1", ClientIP(req, cfg))
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+const defaultMaxKeys = 10000
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a keyed token bucket limiter. Buckets are kept in an lru list so
+// memory stays bounded by maxKeys no matter how many clients we see. An evicted
+// key simply starts again with a full bucket.
+type Limiter struct {
+	rate    float64
+	burst   float64
+	maxKeys int
+	buckets map[string]*list.Element
+	lru     *list.List
+	lock    sync.Mutex
+	now     func() time.Time
+}
+
+func NewLimiter(rate float64, burst int, maxKeys int) *Limiter {
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		maxKeys: maxKeys,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket for key. If the bucket is empty it
+// returns false along with how long the caller should wait before retrying.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+
+	var b *bucket
+	if elem, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(elem)
+		b = elem.Value.(*bucket)
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	} else {
+		b = &bucket{key: key, tokens: l.burst, last: now}
+		l.buckets[key] = l.lru.PushFront(b)
+		l.evict()
+	}
+
+	if b.tokens >= 1 {
+		b.tokens -= 1
+		return true, 0
+	}
+
+	if l.rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// Len returns the number of buckets currently held in memory.
+func (l *Limiter) Len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.lru.Len()
+}
+
+func (l *Limiter) evict() {
+	for l.lru.Len() > l.maxKeys {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*bucket).key)
+	}
+}
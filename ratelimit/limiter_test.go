@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiterBurstAndRefill(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(1, 3, 10)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("client")
+		assert.True(t, ok)
+	}
+
+	ok, wait := l.Allow("client")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	// other keys have their own bucket
+	ok, _ = l.Allow("other")
+	assert.True(t, ok)
+
+	now = now.Add(time.Second)
+	ok, _ = l.Allow("client")
+	assert.True(t, ok)
+	ok, _ = l.Allow("client")
+	assert.False(t, ok)
+}
+
+func TestLimiterBoundedKeys(t *testing.T) {
+	l := NewLimiter(1, 1, 2)
+
+	l.Allow("a")
+	l.Allow("b")
+	l.Allow("c")
+	assert.Equal(t, 2, l.Len())
+
+	// "a" was evicted so it gets a fresh bucket
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	// "c" is still tracked and empty
+	ok, _ = l.Allow("c")
+	assert.False(t, ok)
+}
@@ -2,35 +2,184 @@ package shortener
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"github.com/moh-osman3/shortener/config"
 	"github.com/moh-osman3/shortener/managers"
+	"github.com/moh-osman3/shortener/metrics"
+	"github.com/moh-osman3/shortener/ratelimit"
+	"github.com/moh-osman3/shortener/tracing"
 )
 
 type server struct {
-	manager managers.UrlManager
-	logger  *zap.Logger
-	server  http.Server
+	manager       managers.UrlManager
+	logger        *zap.Logger
+	config        *config.Config
+	mux           *http.ServeMux
+	server        http.Server
+	metricsServer *http.Server
 }
 
-func NewServer(m managers.UrlManager, logger *zap.Logger, port string) *server {
-	return &server{
+func NewServer(m managers.UrlManager, logger *zap.Logger, cfg *config.Config) *server {
+	mux := http.NewServeMux()
+	s := &server{
 		manager: m,
 		logger:  logger,
-		server:  http.Server{Addr: fmt.Sprintf(":%s", port)},
+		config:  cfg,
+		mux:     mux,
+		server:  http.Server{Addr: fmt.Sprintf(":%s", cfg.Port), Handler: mux},
+	}
+
+	if cfg.MetricsPort != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+		s.metricsServer = &http.Server{Addr: fmt.Sprintf(":%s", cfg.MetricsPort), Handler: metricsMux}
+	}
+	return s
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.code = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// Flush passes flushes through so event streams work behind the recorder.
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
 	}
 }
 
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// instrument records request counts and latencies for a route and starts the
+// server span for the request, continuing the trace from the W3C traceparent
+// header if the caller sent one. The route is the registered pattern rather
+// than the request path to keep the number of label values bounded.
+func (s *server) instrument(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		sr := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next(sr, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(sr.code))
+		if sr.code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sr.code))
+		}
+		metrics.RequestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+		metrics.RequestsTotal.WithLabelValues(route, strconv.Itoa(sr.code)).Inc()
+	}
+}
+
+func (s *server) handle(route string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(route, s.instrument(route, handler))
+}
+
 func (s *server) AddDefaultRoutes() {
-	http.HandleFunc("/create", s.manager.CreateUrlHandleFunc)
-	http.HandleFunc("/delete", s.manager.DeleteUrlHandleFunc)
-	http.HandleFunc("/", s.manager.GetUrlHandleFunc)
+	create := s.manager.CreateUrlHandleFunc
+	redirect := s.manager.GetUrlHandleFunc
+
+	rl := s.config.RateLimit
+	if rl.Enabled {
+		perKey := ratelimit.NewLimiter(rl.CreatePerKey.Rate, rl.CreatePerKey.Burst, rl.MaxKeys)
+		perIP := ratelimit.NewLimiter(rl.CreatePerIP.Rate, rl.CreatePerIP.Burst, rl.MaxKeys)
+		redirectPerIP := ratelimit.NewLimiter(rl.RedirectPerIP.Rate, rl.RedirectPerIP.Burst, rl.MaxKeys)
+
+		// the api key limiter is checked first so rejected keys don't use up
+		// tokens for everyone else on the same ip. Requests without an api key
+		// are only limited by ip.
+		create = s.rateLimit(perIP, s.clientIP, create)
+		create = s.rateLimit(perKey, s.apiKey, create)
+		redirect = s.rateLimit(redirectPerIP, s.clientIP, redirect)
+	}
+
+	s.handle("/create", create)
+	s.handle("/delete", s.manager.DeleteUrlHandleFunc)
+	s.handle("/renew", s.manager.RenewUrlHandleFunc)
+	s.handle("/sign", s.manager.SignUrlHandleFunc)
+	s.handle("/admin/disable", s.manager.DisableUrlHandleFunc)
+	s.handle("/admin/enable", s.manager.EnableUrlHandleFunc)
+	s.handle("/admin/urls/", s.manager.AdminGetUrlHandleFunc)
+	s.handle("/admin/trash", s.manager.ListTrashHandleFunc)
+	s.handle("/admin/restore", s.manager.RestoreUrlHandleFunc)
+	s.handle("/admin/webhooks", s.manager.WebhooksHandleFunc)
+	s.handle("/admin/webhooks/deliveries", s.manager.WebhookDeliveriesHandleFunc)
+	s.handle("/admin/webhooks/redeliver", s.manager.RedeliverWebhookHandleFunc)
+	s.handle("/admin/events", s.manager.EventsHandleFunc)
+	s.handle("/", redirect)
+}
+
+// rateLimit wraps next so that each request takes a token from the bucket
+// belonging to keyFn(r). An empty key skips the limiter.
+func (s *server) rateLimit(l *ratelimit.Limiter, keyFn func(r *http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := keyFn(r)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		ok, wait := l.Allow(key)
+		if !ok {
+			s.logger.Debug("server.go: rate limited request", zap.String("path", r.URL.Path))
+			retryAfter := int64(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *server) apiKey(r *http.Request) string {
+	return r.Header.Get(managers.ApiKeyHeader)
+}
+
+func (s *server) clientIP(r *http.Request) string {
+	return ratelimit.ClientIP(r, s.config.RateLimit)
 }
 
 func (s *server) Serve() error {
-	s.logger.Info("Starting server on port 3030")
+	if s.metricsServer != nil {
+		go func() {
+			s.logger.Info("Starting metrics server", zap.String("addr", s.metricsServer.Addr))
+			err := s.metricsServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				s.logger.Error("metrics server failed", zap.Error(err))
+			}
+		}()
+	}
+
+	s.logger.Info("Starting server", zap.String("addr", s.server.Addr))
 
 	err := s.server.ListenAndServe()
 	s.logger.Info("Shutting down server")
@@ -38,5 +187,8 @@ func (s *server) Serve() error {
 }
 
 func (s *server) Shutdown() error {
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
 	return s.server.Close()
 }
@@ -3,11 +3,23 @@ package shortener
 import (
 	"context"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/config"
+	"github.com/moh-osman3/shortener/managers"
+	"github.com/moh-osman3/shortener/metrics"
 )
 
 type mockUrlManager struct{}
@@ -21,6 +33,39 @@ func (m *mockUrlManager) DeleteUrlHandleFunc(w http.ResponseWriter, r *http.Requ
 func (m *mockUrlManager) GetUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
 	return
 }
+func (m *mockUrlManager) DisableUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	return
+}
+func (m *mockUrlManager) EnableUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	return
+}
+func (m *mockUrlManager) AdminGetUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	return
+}
+func (m *mockUrlManager) ListTrashHandleFunc(w http.ResponseWriter, r *http.Request) {
+	return
+}
+func (m *mockUrlManager) RestoreUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	return
+}
+func (m *mockUrlManager) RenewUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	return
+}
+func (m *mockUrlManager) SignUrlHandleFunc(w http.ResponseWriter, r *http.Request) {
+	return
+}
+func (m *mockUrlManager) WebhooksHandleFunc(w http.ResponseWriter, r *http.Request) {
+	return
+}
+func (m *mockUrlManager) WebhookDeliveriesHandleFunc(w http.ResponseWriter, r *http.Request) {
+	return
+}
+func (m *mockUrlManager) RedeliverWebhookHandleFunc(w http.ResponseWriter, r *http.Request) {
+	return
+}
+func (m *mockUrlManager) EventsHandleFunc(w http.ResponseWriter, r *http.Request) {
+	return
+}
 func (m *mockUrlManager) Start(ctx context.Context, cacheInterval time.Duration, dbInterval time.Duration) error {
 	return nil
 }
@@ -29,7 +74,10 @@ func (m *mockUrlManager) End() {
 }
 
 func TestBasicServer(t *testing.T) {
-	server := NewServer(&mockUrlManager{}, zap.NewNop(), "3131")
+	cfg := config.Default()
+	cfg.Port = "3131"
+	cfg.MetricsPort = "3132"
+	server := NewServer(&mockUrlManager{}, zap.NewNop(), cfg)
 	assert.NotNil(t, server)
 
 	errs := make(chan error, 1)
@@ -38,9 +86,110 @@ func TestBasicServer(t *testing.T) {
 	}()
 
 	time.Sleep(5 * time.Second)
-	err := server.Shutdown()
+
+	resp, err := http.Get("http://localhost:3132/metrics")
+	assert.NoError(t, err)
+	if err == nil {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	err = server.Shutdown()
 	assert.NoError(t, err)
 	err = <-errs
 	assert.Error(t, err)
 	assert.ErrorContains(t, err, "Server closed")
 }
+
+func TestRateLimitRoutes(t *testing.T) {
+	cfg := config.Default()
+	cfg.RateLimit.CreatePerKey = config.LimitConfig{Rate: 1, Burst: 1}
+	cfg.RateLimit.CreatePerIP = config.LimitConfig{Rate: 1, Burst: 2}
+	cfg.RateLimit.RedirectPerIP = config.LimitConfig{Rate: 1, Burst: 3}
+	server := NewServer(&mockUrlManager{}, zap.NewNop(), cfg)
+	server.AddDefaultRoutes()
+
+	doRequest := func(method string, path string, apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if apiKey != "" {
+			req.Header.Set(managers.ApiKeyHeader, apiKey)
+		}
+		w := httptest.NewRecorder()
+		server.mux.ServeHTTP(w, req)
+		return w
+	}
+
+	// per api key limit
+	assert.Equal(t, http.StatusOK, doRequest(http.MethodPost, "/create", "key1").Code)
+	w := doRequest(http.MethodPost, "/create", "key1")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// per ip limit applies across api keys
+	assert.Equal(t, http.StatusOK, doRequest(http.MethodPost, "/create", "key2").Code)
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(http.MethodPost, "/create", "key3").Code)
+
+	// redirects have their own limit
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, doRequest(http.MethodGet, "/abc", "").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, doRequest(http.MethodGet, "/abc", "").Code)
+}
+
+func TestClientIP(t *testing.T) {
+	cfg := config.Default()
+	server := NewServer(&mockUrlManager{}, zap.NewNop(), cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 1.2.3.4")
+	assert.Equal(t, "10.0.0.1", server.clientIP(req))
+
+	// the leftmost entry is set by the client, the proxy appends the real one
+	cfg.RateLimit.TrustProxyHeaders = true
+	assert.Equal(t, "1.2.3.4", server.clientIP(req))
+	req.Header.Set("X-Forwarded-For", "7.7.7.7, 1.2.3.4")
+	assert.Equal(t, "1.2.3.4", server.clientIP(req))
+}
+
+func TestInstrumentRoutes(t *testing.T) {
+	cfg := config.Default()
+	cfg.RateLimit.Enabled = false
+	server := NewServer(&mockUrlManager{}, zap.NewNop(), cfg)
+	server.AddDefaultRoutes()
+
+	before := testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("/create", "200"))
+	w := httptest.NewRecorder()
+	server.mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/create", nil))
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("/create", "200")))
+
+	// teapot is recorded with its status code
+	handler := server.instrument("/teapot", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/teapot", nil))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.RequestsTotal.WithLabelValues("/teapot", "418")))
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	cfg := config.Default()
+	server := NewServer(&mockUrlManager{}, zap.NewNop(), cfg)
+	server.AddDefaultRoutes()
+
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	server.mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GET /", spans[0].Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+}
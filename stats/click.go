@@ -0,0 +1,116 @@
+package stats
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DimensionReferrer = "referrer"
+	DimensionBrowser  = "browser"
+	DimensionDevice   = "device"
+	DimensionOS       = "os"
+	DimensionLanguage = "language"
+	DimensionCountry  = "country"
+	DimensionRegion   = "region"
+
+	// value of dimensions that could not be read from the request
+	Unknown = "unknown"
+
+	// referrer used for clicks without a Referer header
+	directReferrer = "direct"
+	// longest dimension value kept, longer values are cut off
+	maxValueLength = 253
+)
+
+// Click is a single call to a short url with the dimensions it is aggregated
+// by. Empty dimensions are not recorded. The client ip is never part of a
+// click, only the location it was resolved to and the visitor hash.
+type Click struct {
+	Time     time.Time
+	Referrer string
+	Browser  string
+	Device   string
+	OS       string
+	Language string
+	Country  string
+	Region   string
+	// see VisitorHash, 0 when the visitor is unknown
+	Visitor uint64
+	// bot clicks are only counted in the bot bucket, without dimensions
+	Bot bool
+}
+
+type dimension struct {
+	name  string
+	value string
+}
+
+func (c Click) dimensions() []dimension {
+	return []dimension{
+		{DimensionReferrer, c.Referrer},
+		{DimensionBrowser, c.Browser},
+		{DimensionDevice, c.Device},
+		{DimensionOS, c.OS},
+		{DimensionLanguage, c.Language},
+		{DimensionCountry, c.Country},
+		{DimensionRegion, c.Region},
+	}
+}
+
+// NewClick reads the dimensions of a click from the redirect request.
+func NewClick(r *http.Request, now time.Time) Click {
+	agent := ParseUserAgent(r.UserAgent())
+	return Click{
+		Time:     now,
+		Referrer: referrerHost(r.Referer()),
+		Browser:  agent.Browser,
+		Device:   agent.Device,
+		OS:       agent.OS,
+		Language: primaryLanguage(r.Header.Get("Accept-Language")),
+	}
+}
+
+// referrerHost keeps only the host of the referrer so paths and query strings,
+// which may carry personal data, are never stored.
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return directReferrer
+	}
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return Unknown
+	}
+	return truncate(strings.ToLower(u.Hostname()))
+}
+
+// primaryLanguage returns the primary subtag of the preferred language in an
+// Accept-Language header, e.g. "en" for "en-US,en;q=0.9".
+func primaryLanguage(header string) string {
+	if header == "" {
+		return Unknown
+	}
+	first, _, _ := strings.Cut(header, ",")
+	first, _, _ = strings.Cut(first, ";")
+	tag, _, _ := strings.Cut(strings.TrimSpace(first), "-")
+	tag = strings.ToLower(tag)
+
+	if len(tag) < 2 || len(tag) > 8 {
+		return Unknown
+	}
+	for _, c := range tag {
+		if c < 'a' || c > 'z' {
+			return Unknown
+		}
+	}
+	return tag
+}
+
+func truncate(value string) string {
+	if len(value) > maxValueLength {
+		return value[:maxValueLength]
+	}
+	return value
+}
@@ -0,0 +1,88 @@
+package stats
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua       string
+		expected UserAgent
+	}{
+		{
+			ua:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			expected: UserAgent{Browser: "Chrome", Device: "desktop", OS: "Windows"},
+		},
+		{
+			ua:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0",
+			expected: UserAgent{Browser: "Edge", Device: "desktop", OS: "Windows"},
+		},
+		{
+			ua:       "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+			expected: UserAgent{Browser: "Safari", Device: "mobile", OS: "iOS"},
+		},
+		{
+			ua:       "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0 Mobile/15E148 Safari/604.1",
+			expected: UserAgent{Browser: "Chrome", Device: "tablet", OS: "iOS"},
+		},
+		{
+			ua:       "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			expected: UserAgent{Browser: "Chrome", Device: "mobile", OS: "Android"},
+		},
+		{
+			ua:       "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Safari/537.36",
+			expected: UserAgent{Browser: "Samsung Internet", Device: "tablet", OS: "Android"},
+		},
+		{
+			ua:       "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.1; rv:121.0) Gecko/20100101 Firefox/121.0",
+			expected: UserAgent{Browser: "Firefox", Device: "desktop", OS: "macOS"},
+		},
+		{
+			ua:       "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 OPR/105.0.0.0",
+			expected: UserAgent{Browser: "Opera", Device: "desktop", OS: "Linux"},
+		},
+		{
+			ua:       "curl/8.4.0",
+			expected: UserAgent{Browser: "curl", Device: "other", OS: "Other"},
+		},
+		{
+			ua:       "",
+			expected: UserAgent{Browser: Unknown, Device: Unknown, OS: Unknown},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, ParseUserAgent(test.ua), test.ua)
+	}
+}
+
+func TestNewClick(t *testing.T) {
+	now := time.Now()
+	r := httptest.NewRequest("GET", "/abc", nil)
+	r.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0")
+	r.Header.Set("Referer", "https://News.Example.com/some/article?user=1234")
+	r.Header.Set("Accept-Language", "de-CH, de;q=0.9, en;q=0.8")
+
+	click := NewClick(r, now)
+	assert.Equal(t, Click{
+		Time:     now,
+		Referrer: "news.example.com",
+		Browser:  "Firefox",
+		Device:   "desktop",
+		OS:       "Windows",
+		Language: "de",
+	}, click)
+
+	// requests without headers are still counted
+	click = NewClick(httptest.NewRequest("GET", "/abc", nil), now)
+	assert.Equal(t, directReferrer, click.Referrer)
+	assert.Equal(t, Unknown, click.Browser)
+	assert.Equal(t, Unknown, click.Language)
+
+	assert.Equal(t, Unknown, primaryLanguage("*"))
+	assert.Equal(t, Unknown, referrerHost("not a url"))
+}
@@ -0,0 +1,145 @@
+package stats
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"net"
+)
+
+// Unique visitors are estimated with HyperLogLog sketches of 2^hllPrecision
+// registers, which have a standard error of about 1.6%. A sketch is stored per
+// short url per day; sketches merge losslessly, so the week and all time
+// estimates come from merging the day sketches.
+const (
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+
+	// most days only see a few visitors, so sketches with few registers set
+	// are stored as (index, value) pairs instead of the full register array
+	hllSparse = 1
+	hllDense  = 2
+	// bytes per register in the sparse encoding
+	hllSparseEntrySize = 3
+)
+
+var errInvalidSketch = errors.New("hll.go: invalid sketch encoding")
+
+// VisitorHash identifies a visitor by a salted hash of their ip address and
+// user agent. Only the hash is used for counting, so the ip can't be recovered
+// from the stored sketches. It returns 0 when the ip is unknown.
+func VisitorHash(salt []byte, ip net.IP, userAgent string) uint64 {
+	if ip == nil {
+		return 0
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ip.To16())
+	mac.Write([]byte{0})
+	mac.Write([]byte(userAgent))
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// Sketch is a HyperLogLog sketch of visitor hashes.
+type Sketch struct {
+	registers [hllRegisters]uint8
+}
+
+func NewSketch() *Sketch {
+	return &Sketch{}
+}
+
+// Insert adds a visitor hash to the sketch.
+func (s *Sketch) Insert(hash uint64) {
+	idx := hash >> (64 - hllPrecision)
+	// the guard bit bounds the rank when the remaining bits are all zero
+	rest := hash<<hllPrecision | 1<<(hllPrecision-1)
+	rank := uint8(bits.LeadingZeros64(rest) + 1)
+	if rank > s.registers[idx] {
+		s.registers[idx] = rank
+	}
+}
+
+// Merge adds the visitors of other to the sketch.
+func (s *Sketch) Merge(other *Sketch) {
+	for i, rank := range other.registers {
+		if rank > s.registers[i] {
+			s.registers[i] = rank
+		}
+	}
+}
+
+// Estimate returns the approximate number of distinct visitors.
+func (s *Sketch) Estimate() int64 {
+	m := float64(hllRegisters)
+	sum := 0.0
+	zeros := 0
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	// linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
+
+func (s *Sketch) Marshal() []byte {
+	set := 0
+	for _, rank := range s.registers {
+		if rank != 0 {
+			set++
+		}
+	}
+
+	if set*hllSparseEntrySize >= hllRegisters {
+		return append([]byte{hllDense}, s.registers[:]...)
+	}
+	data := make([]byte, 1, 1+set*hllSparseEntrySize)
+	data[0] = hllSparse
+	for i, rank := range s.registers {
+		if rank != 0 {
+			data = binary.BigEndian.AppendUint16(data, uint16(i))
+			data = append(data, rank)
+		}
+	}
+	return data
+}
+
+func (s *Sketch) Unmarshal(data []byte) error {
+	if len(data) == 0 {
+		return errInvalidSketch
+	}
+
+	switch data[0] {
+	case hllDense:
+		if len(data) != 1+hllRegisters {
+			return errInvalidSketch
+		}
+		copy(s.registers[:], data[1:])
+	case hllSparse:
+		entries := data[1:]
+		if len(entries)%hllSparseEntrySize != 0 {
+			return errInvalidSketch
+		}
+		s.registers = [hllRegisters]uint8{}
+		for i := 0; i < len(entries); i += hllSparseEntrySize {
+			idx := binary.BigEndian.Uint16(entries[i:])
+			if int(idx) >= hllRegisters {
+				return errInvalidSketch
+			}
+			s.registers[idx] = entries[i+2]
+		}
+	default:
+		return errInvalidSketch
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package stats
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testHash(i int) uint64 {
+	sum := sha256.Sum256(binary.BigEndian.AppendUint64(nil, uint64(i)))
+	return binary.BigEndian.Uint64(sum[:])
+}
+
+func TestSketchEstimate(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 10000, 200000} {
+		sketch := NewSketch()
+		for i := 0; i < n; i++ {
+			sketch.Insert(testHash(i))
+			// repeat visits don't change the estimate
+			sketch.Insert(testHash(i))
+		}
+
+		estimate := float64(sketch.Estimate())
+		assert.InDelta(t, float64(n), estimate, math.Max(1, 0.05*float64(n)), "n=%d", n)
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	a, b, union := NewSketch(), NewSketch(), NewSketch()
+	for i := 0; i < 3000; i++ {
+		a.Insert(testHash(i))
+		union.Insert(testHash(i))
+	}
+	for i := 2000; i < 5000; i++ {
+		b.Insert(testHash(i))
+		union.Insert(testHash(i))
+	}
+
+	a.Merge(b)
+	assert.Equal(t, union.registers, a.registers)
+	assert.InDelta(t, 5000, float64(a.Estimate()), 250)
+}
+
+func TestSketchMarshal(t *testing.T) {
+	for _, n := range []int{1, 100, 10000} {
+		sketch := NewSketch()
+		for i := 0; i < n; i++ {
+			sketch.Insert(testHash(i))
+		}
+
+		data := sketch.Marshal()
+		if n <= 100 {
+			// small sketches only store the registers that are set
+			assert.Equal(t, byte(hllSparse), data[0])
+			assert.LessOrEqual(t, len(data), 1+n*hllSparseEntrySize)
+		} else {
+			assert.Equal(t, byte(hllDense), data[0])
+			assert.Len(t, data, 1+hllRegisters)
+		}
+
+		decoded := NewSketch()
+		require.NoError(t, decoded.Unmarshal(data))
+		assert.Equal(t, sketch.registers, decoded.registers)
+	}
+
+	for _, bad := range [][]byte{nil, {0}, {hllDense, 1, 2}, {hllSparse, 0, 1}, {hllSparse, 0xff, 0xff, 1}} {
+		assert.Error(t, NewSketch().Unmarshal(bad))
+	}
+}
+
+func TestVisitorHash(t *testing.T) {
+	ip := net.ParseIP("203.0.113.7")
+	salt := []byte("salt")
+
+	assert.Equal(t, VisitorHash(salt, ip, "ua"), VisitorHash(salt, ip, "ua"))
+	assert.Equal(t, VisitorHash(salt, ip, "ua"), VisitorHash(salt, ip.To16(), "ua"))
+	assert.NotEqual(t, VisitorHash(salt, ip, "ua"), VisitorHash(salt, ip, "other ua"))
+	assert.NotEqual(t, VisitorHash(salt, ip, "ua"), VisitorHash(salt, net.ParseIP("203.0.113.8"), "ua"))
+	assert.NotEqual(t, VisitorHash(salt, ip, "ua"), VisitorHash([]byte("other salt"), ip, "ua"))
+	assert.Equal(t, uint64(0), VisitorHash(salt, nil, "ua"))
+}
+
+func TestUniqueVisitors(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+
+	// visitors 0-9 today, 5-14 three days ago and 100-119 a month ago
+	var clicks []Click
+	for i := 0; i < 10; i++ {
+		clicks = append(clicks, Click{Time: now, Visitor: testHash(i)}, Click{Time: now, Visitor: testHash(i)})
+	}
+	for i := 5; i < 15; i++ {
+		clicks = append(clicks, Click{Time: now.AddDate(0, 0, -3), Visitor: testHash(i)})
+	}
+	for i := 100; i < 120; i++ {
+		clicks = append(clicks, Click{Time: now.AddDate(0, -1, 0), Visitor: testHash(i)})
+	}
+	// clicks without a visitor are only counted as calls
+	clicks = append(clicks, Click{Time: now})
+	addClicks(t, db, "abc", clicks...)
+
+	counts, err := GetCounts(db, "abc", now)
+	require.NoError(t, err)
+	assert.Equal(t, Counts{Day: 21, Week: 31, Total: 51, UniqueDay: 10, UniqueWeek: 15, UniqueTotal: 35}, counts)
+
+	report, err := Query(db, "abc", Options{From: now.AddDate(0, 0, -3), To: now})
+	require.NoError(t, err)
+	assert.Equal(t, int64(31), report.Total)
+	assert.Equal(t, int64(15), report.Visitors)
+}
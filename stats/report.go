@@ -0,0 +1,239 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	GranularityHour  = "hour"
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+
+	// bounds the size of a report
+	maxSeriesBuckets = 10000
+)
+
+// Point is the number of calls in the bucket starting at Time.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Calls int64     `json:"calls"`
+}
+
+// Options selects the range and the buckets of a report. Buckets start on
+// hour, day, week (monday) or month boundaries in Location.
+type Options struct {
+	From        time.Time
+	To          time.Time
+	Granularity string
+	Location    *time.Location
+	// hourly stats before this time have been pruned, the zero time means
+	// they are all kept
+	HourlyFrom time.Time
+}
+
+// Report aggregates the stats of a short url between From and To. The series
+// has a point for every bucket in the range, including empty ones. Bot calls
+// are reported separately from the calls in the series. Visitors, bot calls
+// and dimension breakdowns are kept per utc day, so they include the whole
+// first and last day of the range. TruncatedBefore is set when the series is
+// counted from hourly stats that were pruned for part of the range, it has no
+// calls before that time.
+type Report struct {
+	From        time.Time                   `json:"from"`
+	To          time.Time                   `json:"to"`
+	Granularity string                      `json:"granularity"`
+	Timezone    string                      `json:"timezone"`
+	Total       int64                       `json:"total"`
+	Visitors    int64                       `json:"visitors"`
+	Bots        int64                       `json:"bots"`
+	Series      []Point                     `json:"series"`
+	Dimensions  map[string]map[string]int64 `json:"dimensions"`
+	// nil unless the series misses the pruned part of the range
+	TruncatedBefore *time.Time `json:"truncated_before,omitempty"`
+}
+
+func truncateToDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// bucketStart returns the start of the bucket containing t.
+func bucketStart(t time.Time, granularity string, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch granularity {
+	case GranularityHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	case GranularityWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	}
+}
+
+func nextBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityHour:
+		return start.Add(time.Hour)
+	case GranularityWeek:
+		return start.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// Validate checks the options and fills in the defaults: day buckets in utc.
+func (o *Options) Validate() error {
+	if o.Location == nil {
+		o.Location = time.UTC
+	}
+	switch o.Granularity {
+	case "":
+		o.Granularity = GranularityDay
+	case GranularityHour, GranularityDay, GranularityWeek, GranularityMonth:
+	default:
+		return fmt.Errorf("report.go: unknown granularity %q", o.Granularity)
+	}
+	if o.From.After(o.To) {
+		return errors.New("report.go: from must not be after to")
+	}
+
+	buckets := 0
+	for start := bucketStart(o.From, o.Granularity, o.Location); !start.After(o.To); start = nextBucket(start, o.Granularity) {
+		buckets++
+		if buckets > maxSeriesBuckets {
+			return fmt.Errorf("report.go: range has more than %d buckets, use a larger granularity", maxSeriesBuckets)
+		}
+	}
+	return nil
+}
+
+// Query builds the report of a short url. Hour buckets, and buckets in
+// timezones other than utc, are counted from the hourly stats so they are
+// limited to the hourly retention. Utc day, week and month buckets are
+// counted from the daily stats.
+func Query(db Reader, id string, opts Options) (Report, error) {
+	if err := opts.Validate(); err != nil {
+		return Report{}, err
+	}
+
+	report := Report{
+		From:        opts.From,
+		To:          opts.To,
+		Granularity: opts.Granularity,
+		Timezone:    opts.Location.String(),
+		Series:      []Point{},
+		Dimensions:  make(map[string]map[string]int64),
+	}
+	for start := bucketStart(opts.From, opts.Granularity, opts.Location); !start.After(opts.To); start = nextBucket(start, opts.Granularity) {
+		report.Series = append(report.Series, Point{Time: start})
+	}
+	seriesStart := report.Series[0].Time
+
+	seriesKind := kindDay
+	if opts.Granularity == GranularityHour || opts.Location != time.UTC {
+		seriesKind = kindHour
+		if !opts.HourlyFrom.IsZero() && opts.From.Before(opts.HourlyFrom) {
+			truncated := opts.HourlyFrom
+			report.TruncatedBefore = &truncated
+		}
+	}
+	firstDay := truncateToDay(opts.From)
+	visitors := NewSketch()
+
+	iter := db.NewIterator(util.BytesPrefix(linkPrefix(id)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key, ok := parseKey(iter.Key())
+		if !ok || key.time.After(opts.To) {
+			continue
+		}
+
+		switch key.kind {
+		case seriesKind:
+			if key.time.Before(seriesStart) {
+				continue
+			}
+			count, err := strconv.ParseInt(string(iter.Value()), 10, 64)
+			if err != nil {
+				continue
+			}
+			// the last bucket that starts at or before the stats bucket
+			idx := sort.Search(len(report.Series), func(i int) bool {
+				return report.Series[i].Time.After(key.time)
+			}) - 1
+			report.Series[idx].Calls += count
+			report.Total += count
+		case kindVisitors:
+			sketch := NewSketch()
+			if !key.time.Before(firstDay) && sketch.Unmarshal(iter.Value()) == nil {
+				visitors.Merge(sketch)
+			}
+		case kindBots:
+			if key.time.Before(firstDay) {
+				continue
+			}
+			count, err := strconv.ParseInt(string(iter.Value()), 10, 64)
+			if err == nil {
+				report.Bots += count
+			}
+		case kindDay, kindHour:
+		default:
+			if key.time.Before(firstDay) {
+				continue
+			}
+			count, err := strconv.ParseInt(string(iter.Value()), 10, 64)
+			if err != nil {
+				continue
+			}
+			values, ok := report.Dimensions[key.kind]
+			if !ok {
+				values = make(map[string]int64)
+				report.Dimensions[key.kind] = values
+			}
+			values[key.value] += count
+		}
+	}
+	report.Visitors = visitors.Estimate()
+	return report, iter.Error()
+}
+
+// Prune removes hourly buckets older than hourlyRetention and day, visitor,
+// bot and dimension buckets older than dailyRetention. A retention of 0 keeps the
+// buckets forever. It returns the number of keys added to the batch.
+func Prune(db Reader, batch *leveldb.Batch, now time.Time, hourlyRetention time.Duration, dailyRetention time.Duration) (int, error) {
+	pruned := 0
+	iter := db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key, ok := parseKey(iter.Key())
+		if !ok {
+			continue
+		}
+
+		var expired bool
+		if key.kind == kindHour {
+			// the bucket is kept until its whole hour is out of the window
+			expired = hourlyRetention > 0 && now.Sub(key.time.Add(time.Hour)) > hourlyRetention
+		} else {
+			expired = dailyRetention > 0 && now.Sub(key.time.AddDate(0, 0, 1)) > dailyRetention
+		}
+		if expired {
+			batch.Delete(append([]byte{}, iter.Key()...))
+			pruned++
+		}
+	}
+	return pruned, iter.Error()
+}
@@ -0,0 +1,301 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Click counters live in their own keyspace, separate from the short url
+// records, so recording a click is a small write that never touches the
+// record itself. Each short url has one bucket per (utc) day, one per hour and
+// one per day for every value of the click dimensions:
+//
+//	stats:<id>:d:<yyyymmdd>           -> number of calls that day
+//	stats:<id>:h:<yyyymmddhh>         -> number of calls that hour
+//	stats:<id>:u:<yyyymmdd>           -> sketch of the unique visitors that day
+//	stats:<id>:b:<yyyymmdd>           -> number of bot calls that day
+//	stats:<id>:<dim>:<yyyymmdd>:<val> -> number of calls that day with dim = val
+//
+// Short url ids never contain a ':', dimension values may.
+const (
+	prefix     = "stats:"
+	dayFormat  = "20060102"
+	hourFormat = "2006010215"
+
+	kindDay      = "d"
+	kindHour     = "h"
+	kindVisitors = "u"
+	kindBots     = "b"
+)
+
+// Reader is the subset of the db that stats needs. Writes go through a
+// leveldb batch so they can be committed together with other changes.
+type Reader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+}
+
+// Counts is the number of calls to a short url, the estimated number of
+// unique visitors and the number of bot calls in the last day, the last week
+// and since it was created. Bot calls are not part of the calls or visitors.
+type Counts struct {
+	Day   int64
+	Week  int64
+	Total int64
+
+	UniqueDay   int64
+	UniqueWeek  int64
+	UniqueTotal int64
+
+	BotDay   int64
+	BotWeek  int64
+	BotTotal int64
+}
+
+// Add sums the counts. Unique visitors only add up when the two counts cover
+// different visitors, e.g. legacy counters that never tracked visitors.
+func (c Counts) Add(other Counts) Counts {
+	return Counts{
+		Day:         c.Day + other.Day,
+		Week:        c.Week + other.Week,
+		Total:       c.Total + other.Total,
+		UniqueDay:   c.UniqueDay + other.UniqueDay,
+		UniqueWeek:  c.UniqueWeek + other.UniqueWeek,
+		UniqueTotal: c.UniqueTotal + other.UniqueTotal,
+		BotDay:      c.BotDay + other.BotDay,
+		BotWeek:     c.BotWeek + other.BotWeek,
+		BotTotal:    c.BotTotal + other.BotTotal,
+	}
+}
+
+func (c Counts) String() string {
+	return fmt.Sprintf("Summary of shorturl:\n calls in the last day: %d calls\n calls in the last week: %d calls\n total calls since creation: %d calls\n"+
+		" unique visitors in the last day: ~%d\n unique visitors in the last week: ~%d\n unique visitors since creation: ~%d\n"+
+		" bot calls in the last day: %d calls\n bot calls in the last week: %d calls\n total bot calls since creation: %d calls\n",
+		c.Day, c.Week, c.Total, c.UniqueDay, c.UniqueWeek, c.UniqueTotal, c.BotDay, c.BotWeek, c.BotTotal)
+}
+
+func linkPrefix(id string) []byte {
+	return []byte(prefix + id + ":")
+}
+
+func dayKey(id string, t time.Time) []byte {
+	return []byte(prefix + id + ":" + kindDay + ":" + t.UTC().Format(dayFormat))
+}
+
+func visitorsKey(id string, t time.Time) []byte {
+	return []byte(prefix + id + ":" + kindVisitors + ":" + t.UTC().Format(dayFormat))
+}
+
+func botsKey(id string, t time.Time) []byte {
+	return []byte(prefix + id + ":" + kindBots + ":" + t.UTC().Format(dayFormat))
+}
+
+func hourKey(id string, t time.Time) []byte {
+	return []byte(prefix + id + ":" + kindHour + ":" + t.UTC().Format(hourFormat))
+}
+
+func dimensionKey(id string, dim string, t time.Time, value string) []byte {
+	return []byte(prefix + id + ":" + dim + ":" + t.UTC().Format(dayFormat) + ":" + value)
+}
+
+// statsKey is a parsed key of the stats keyspace. For day and dimension keys
+// the time is the start of the (utc) day, for hour keys the start of the hour.
+type statsKey struct {
+	id    string
+	kind  string
+	time  time.Time
+	value string
+}
+
+func parseKey(key []byte) (statsKey, bool) {
+	rest, ok := strings.CutPrefix(string(key), prefix)
+	if !ok {
+		return statsKey{}, false
+	}
+	parts := strings.SplitN(rest, ":", 4)
+	if len(parts) < 3 {
+		return statsKey{}, false
+	}
+
+	parsed := statsKey{id: parts[0], kind: parts[1]}
+	switch parsed.kind {
+	case kindDay, kindHour, kindVisitors, kindBots:
+		if len(parts) != 3 {
+			return statsKey{}, false
+		}
+	default:
+		if len(parts) != 4 {
+			return statsKey{}, false
+		}
+		parsed.value = parts[3]
+	}
+
+	format := dayFormat
+	if parsed.kind == kindHour {
+		format = hourFormat
+	}
+	t, err := time.Parse(format, parts[2])
+	if err != nil {
+		return statsKey{}, false
+	}
+	parsed.time = t
+	return parsed, true
+}
+
+func getInt(db Reader, key []byte) (int64, error) {
+	val, err := db.Get(key, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(val), 10, 64)
+}
+
+func getSketch(db Reader, key []byte) (*Sketch, error) {
+	sketch := NewSketch()
+	val, err := db.Get(key, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return sketch, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sketch, sketch.Unmarshal(val)
+}
+
+// AddClicks adds the clicks to the day, hour, visitor and dimension buckets of
+// the short url. Bot clicks only go to the bot bucket of the day. The caller
+// must make sure no other writer updates the same buckets until the batch is
+// written.
+func AddClicks(db Reader, batch *leveldb.Batch, id string, clicks []Click) error {
+	increments := make(map[string]int64)
+	visitors := make(map[string][]uint64)
+	for _, click := range clicks {
+		if click.Bot {
+			increments[string(botsKey(id, click.Time))]++
+			continue
+		}
+		if click.Visitor != 0 {
+			key := string(visitorsKey(id, click.Time))
+			visitors[key] = append(visitors[key], click.Visitor)
+		}
+		increments[string(dayKey(id, click.Time))]++
+		increments[string(hourKey(id, click.Time))]++
+		for _, dim := range click.dimensions() {
+			if dim.value == "" {
+				continue
+			}
+			increments[string(dimensionKey(id, dim.name, click.Time, dim.value))]++
+		}
+	}
+
+	for key, calls := range increments {
+		count, err := getInt(db, []byte(key))
+		if err != nil {
+			return err
+		}
+		batch.Put([]byte(key), []byte(strconv.FormatInt(count+calls, 10)))
+	}
+
+	for key, hashes := range visitors {
+		sketch, err := getSketch(db, []byte(key))
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			sketch.Insert(hash)
+		}
+		batch.Put([]byte(key), sketch.Marshal())
+	}
+	return nil
+}
+
+// sumDays sums the day buckets of one kind. It returns the calls of today, of
+// the week starting at weekStart and of all time.
+func sumDays(db Reader, id string, kind string, today time.Time, weekStart time.Time) (int64, int64, int64, error) {
+	var day, week, total int64
+	iter := db.NewIterator(util.BytesPrefix([]byte(prefix+id+":"+kind+":")), nil)
+	defer iter.Release()
+	for iter.Next() {
+		key, ok := parseKey(iter.Key())
+		if !ok || key.kind != kind {
+			continue
+		}
+		count, err := strconv.ParseInt(string(iter.Value()), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		total += count
+		if !key.time.Before(weekStart) {
+			week += count
+		}
+		if key.time.Equal(today) {
+			day += count
+		}
+	}
+	return day, week, total, iter.Error()
+}
+
+// GetCounts sums the day buckets of a short url. The last day is today (utc)
+// and the last week is today and the 6 days before it.
+func GetCounts(db Reader, id string, now time.Time) (Counts, error) {
+	today, _ := time.Parse(dayFormat, now.UTC().Format(dayFormat))
+	weekStart := today.AddDate(0, 0, -6)
+
+	counts := Counts{}
+	var err error
+	counts.Day, counts.Week, counts.Total, err = sumDays(db, id, kindDay, today, weekStart)
+	if err != nil {
+		return counts, err
+	}
+	counts.BotDay, counts.BotWeek, counts.BotTotal, err = sumDays(db, id, kindBots, today, weekStart)
+	if err != nil {
+		return counts, err
+	}
+
+	day, week, total := NewSketch(), NewSketch(), NewSketch()
+	visitorsIter := db.NewIterator(util.BytesPrefix([]byte(prefix+id+":"+kindVisitors+":")), nil)
+	defer visitorsIter.Release()
+	for visitorsIter.Next() {
+		key, ok := parseKey(visitorsIter.Key())
+		if !ok {
+			continue
+		}
+		sketch := NewSketch()
+		if err := sketch.Unmarshal(visitorsIter.Value()); err != nil {
+			continue
+		}
+
+		total.Merge(sketch)
+		if !key.time.Before(weekStart) {
+			week.Merge(sketch)
+		}
+		if key.time.Equal(today) {
+			day.Merge(sketch)
+		}
+	}
+	counts.UniqueDay, counts.UniqueWeek, counts.UniqueTotal = day.Estimate(), week.Estimate(), total.Estimate()
+	return counts, visitorsIter.Error()
+}
+
+// DeleteAll removes every stats key of the short url.
+func DeleteAll(db Reader, batch *leveldb.Batch, id string) error {
+	iter := db.NewIterator(util.BytesPrefix(linkPrefix(id)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		batch.Delete(append([]byte{}, iter.Key()...))
+	}
+	return iter.Error()
+}
@@ -0,0 +1,274 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func newTestDB(t *testing.T) *leveldb.DB {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func addCalls(t *testing.T, db *leveldb.DB, id string, timestamps ...time.Time) {
+	clicks := make([]Click, 0, len(timestamps))
+	for _, timestamp := range timestamps {
+		clicks = append(clicks, Click{Time: timestamp})
+	}
+	addClicks(t, db, id, clicks...)
+}
+
+func addClicks(t *testing.T, db *leveldb.DB, id string, clicks ...Click) {
+	batch := new(leveldb.Batch)
+	require.NoError(t, AddClicks(db, batch, id, clicks))
+	require.NoError(t, db.Write(batch, nil))
+}
+
+func TestGetCounts(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	addCalls(t, db, "abc", now, now.Add(-time.Hour))
+	addCalls(t, db, "abc", now.AddDate(0, 0, -3))
+	addCalls(t, db, "abc", now.AddDate(0, 0, -6), now.AddDate(0, 0, -7), now.AddDate(0, -2, 0))
+	// buckets of other short urls are not counted, even with a shared prefix
+	addCalls(t, db, "ab", now)
+	addCalls(t, db, "abcd", now)
+
+	counts, err := GetCounts(db, "abc", now)
+	assert.NoError(t, err)
+	assert.Equal(t, Counts{Day: 2, Week: 4, Total: 6}, counts)
+
+	counts, err = GetCounts(db, "missing", now)
+	assert.NoError(t, err)
+	assert.Equal(t, Counts{}, counts)
+}
+
+func TestAddCallsAccumulates(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		addCalls(t, db, "abc", now, now)
+	}
+
+	val, err := db.Get(dayKey("abc", now), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "6", string(val))
+}
+
+func TestDeleteAll(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+
+	addCalls(t, db, "abc", now, now.AddDate(0, 0, -1))
+	addCalls(t, db, "abcd", now)
+
+	batch := new(leveldb.Batch)
+	require.NoError(t, DeleteAll(db, batch, "abc"))
+	require.NoError(t, db.Write(batch, nil))
+
+	counts, err := GetCounts(db, "abc", now)
+	assert.NoError(t, err)
+	assert.Equal(t, Counts{}, counts)
+
+	counts, err = GetCounts(db, "abcd", now)
+	assert.NoError(t, err)
+	assert.Equal(t, Counts{Day: 1, Week: 1, Total: 1}, counts)
+}
+
+func TestParseKey(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	key, ok := parseKey(dayKey("abc", now))
+	assert.True(t, ok)
+	assert.Equal(t, statsKey{id: "abc", kind: kindDay, time: day}, key)
+
+	key, ok = parseKey(hourKey("abc", now))
+	assert.True(t, ok)
+	assert.Equal(t, statsKey{id: "abc", kind: kindHour, time: now.Truncate(time.Hour)}, key)
+
+	// dimension values may contain the separator
+	key, ok = parseKey(dimensionKey("abc", DimensionReferrer, now, "2001:db8::1"))
+	assert.True(t, ok)
+	assert.Equal(t, statsKey{id: "abc", kind: DimensionReferrer, time: day, value: "2001:db8::1"}, key)
+
+	for _, bad := range []string{"abc", "stats:abc", "stats:abc:d:notaday", "stats:abc:d:20240510:extra", "stats:abc:os:20240510"} {
+		_, ok = parseKey([]byte(bad))
+		assert.False(t, ok, bad)
+	}
+}
+
+func utcDate(year int, month time.Month, day, hour int) time.Time {
+	return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+}
+
+// nonEmpty drops the empty buckets of a series.
+func nonEmpty(series []Point) []Point {
+	points := []Point{}
+	for _, point := range series {
+		if point.Calls != 0 {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+func TestQuery(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+
+	chrome := Click{Time: now, Referrer: "news.example.com", Browser: "Chrome", Device: "desktop", OS: "Windows", Language: "en"}
+	safari := Click{Time: now.Add(-2 * time.Hour), Referrer: directReferrer, Browser: "Safari", Device: "mobile", OS: "iOS", Language: "fr"}
+	old := Click{Time: now.AddDate(0, 0, -10), Referrer: "old.example.com", Browser: "Firefox"}
+	addClicks(t, db, "abc", chrome, chrome, safari, old)
+
+	// day buckets in utc by default, including the empty ones
+	report, err := Query(db, "abc", Options{From: now.AddDate(0, 0, -1), To: now})
+	assert.NoError(t, err)
+	assert.Equal(t, GranularityDay, report.Granularity)
+	assert.Equal(t, "UTC", report.Timezone)
+	assert.Equal(t, int64(3), report.Total)
+	assert.Equal(t, []Point{
+		{Time: utcDate(2024, 5, 9, 0), Calls: 0},
+		{Time: utcDate(2024, 5, 10, 0), Calls: 3},
+	}, report.Series)
+	assert.Equal(t, map[string]int64{"news.example.com": 2, directReferrer: 1}, report.Dimensions[DimensionReferrer])
+	assert.Equal(t, map[string]int64{"Chrome": 2, "Safari": 1}, report.Dimensions[DimensionBrowser])
+	assert.Equal(t, map[string]int64{"desktop": 2, "mobile": 1}, report.Dimensions[DimensionDevice])
+	assert.Equal(t, map[string]int64{"Windows": 2, "iOS": 1}, report.Dimensions[DimensionOS])
+	assert.Equal(t, map[string]int64{"en": 2, "fr": 1}, report.Dimensions[DimensionLanguage])
+
+	// hour buckets follow the range to the hour
+	report, err = Query(db, "abc", Options{From: now.Add(-3 * time.Hour), To: now, Granularity: GranularityHour})
+	assert.NoError(t, err)
+	assert.Len(t, report.Series, 4)
+	assert.Equal(t, []Point{
+		{Time: utcDate(2024, 5, 10, 10), Calls: 1},
+		{Time: utcDate(2024, 5, 10, 12), Calls: 2},
+	}, nonEmpty(report.Series))
+	report, err = Query(db, "abc", Options{From: now.Add(-time.Hour), To: now, Granularity: GranularityHour})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), report.Total)
+
+	// arbitrary windows, e.g. the last 90 days by week and by month
+	report, err = Query(db, "abc", Options{From: now.AddDate(0, 0, -90), To: now, Granularity: GranularityWeek})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), report.Total)
+	assert.Equal(t, []Point{
+		{Time: utcDate(2024, 4, 29, 0), Calls: 1},
+		{Time: utcDate(2024, 5, 6, 0), Calls: 3},
+	}, nonEmpty(report.Series))
+	assert.Equal(t, time.Monday, report.Series[0].Time.Weekday())
+	assert.Equal(t, map[string]int64{"Chrome": 2, "Safari": 1, "Firefox": 1}, report.Dimensions[DimensionBrowser])
+
+	report, err = Query(db, "abc", Options{From: now.AddDate(0, 0, -90), To: now, Granularity: GranularityMonth})
+	assert.NoError(t, err)
+	assert.Len(t, report.Series, 4)
+	assert.Equal(t, []Point{
+		{Time: utcDate(2024, 4, 1, 0), Calls: 1},
+		{Time: utcDate(2024, 5, 1, 0), Calls: 3},
+	}, nonEmpty(report.Series))
+
+	// days in another timezone are counted from the hourly buckets
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	addClicks(t, db, "abc", Click{Time: utcDate(2024, 5, 10, 16)})
+	report, err = Query(db, "abc", Options{From: now.AddDate(0, 0, -1), To: utcDate(2024, 5, 10, 18), Location: tokyo})
+	assert.NoError(t, err)
+	assert.Equal(t, "Asia/Tokyo", report.Timezone)
+	assert.Equal(t, []Point{
+		{Time: time.Date(2024, 5, 10, 0, 0, 0, 0, tokyo), Calls: 3},
+		{Time: time.Date(2024, 5, 11, 0, 0, 0, 0, tokyo), Calls: 1},
+	}, nonEmpty(report.Series))
+	assert.Nil(t, report.TruncatedBefore)
+
+	// a window longer than the hourly retention says where its series stops,
+	// utc day buckets come from the daily stats and are complete
+	hourlyFrom := now.AddDate(0, 0, -30).Truncate(time.Hour)
+	report, err = Query(db, "abc", Options{From: now.AddDate(0, 0, -90), To: now, Location: tokyo, HourlyFrom: hourlyFrom})
+	assert.NoError(t, err)
+	require.NotNil(t, report.TruncatedBefore)
+	assert.Equal(t, hourlyFrom, *report.TruncatedBefore)
+	report, err = Query(db, "abc", Options{From: now.AddDate(0, 0, -90), To: now, HourlyFrom: hourlyFrom})
+	assert.NoError(t, err)
+	assert.Nil(t, report.TruncatedBefore)
+	report, err = Query(db, "abc", Options{From: now.AddDate(0, 0, -7), To: now, Location: tokyo, HourlyFrom: hourlyFrom})
+	assert.NoError(t, err)
+	assert.Nil(t, report.TruncatedBefore)
+
+	_, err = Query(db, "abc", Options{From: now, To: now.Add(-time.Hour)})
+	assert.Error(t, err)
+	_, err = Query(db, "abc", Options{From: now.AddDate(0, 0, -1), To: now, Granularity: "minute"})
+	assert.Error(t, err)
+	_, err = Query(db, "abc", Options{From: now.AddDate(-5, 0, 0), To: now, Granularity: GranularityHour})
+	assert.Error(t, err)
+}
+
+func TestPrune(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+
+	addClicks(t, db, "abc",
+		Click{Time: now, Browser: "Chrome"},
+		Click{Time: now.AddDate(0, 0, -3), Browser: "Chrome"},
+		Click{Time: now.AddDate(0, 0, -40), Browser: "Chrome"},
+	)
+
+	// hourly buckets older than a day are dropped, daily ones are kept
+	batch := new(leveldb.Batch)
+	pruned, err := Prune(db, batch, now, 24*time.Hour, 0)
+	require.NoError(t, err)
+	require.NoError(t, db.Write(batch, nil))
+	assert.Equal(t, 2, pruned)
+
+	report, err := Query(db, "abc", Options{From: now.AddDate(0, 0, -60), To: now, Granularity: GranularityHour})
+	assert.NoError(t, err)
+	assert.Len(t, nonEmpty(report.Series), 1)
+	report, err = Query(db, "abc", Options{From: now.AddDate(0, 0, -60), To: now})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), report.Total)
+
+	batch = new(leveldb.Batch)
+	pruned, err = Prune(db, batch, now, 24*time.Hour, 30*24*time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, db.Write(batch, nil))
+	assert.Equal(t, 2, pruned)
+
+	report, err = Query(db, "abc", Options{From: now.AddDate(0, 0, -60), To: now})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), report.Total)
+	assert.Equal(t, map[string]int64{"Chrome": 2}, report.Dimensions[DimensionBrowser])
+}
+
+func TestBotClicks(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Date(2024, 5, 10, 12, 30, 0, 0, time.UTC)
+
+	addClicks(t, db, "abc",
+		Click{Time: now, Browser: "Chrome", Visitor: 1},
+		Click{Time: now, Browser: "Other", Visitor: 2, Bot: true},
+		Click{Time: now.AddDate(0, 0, -3), Bot: true},
+		Click{Time: now.AddDate(0, -1, 0), Bot: true},
+	)
+
+	counts, err := GetCounts(db, "abc", now)
+	require.NoError(t, err)
+	assert.Equal(t, Counts{Day: 1, Week: 1, Total: 1, UniqueDay: 1, UniqueWeek: 1, UniqueTotal: 1, BotDay: 1, BotWeek: 2, BotTotal: 3}, counts)
+
+	// bot calls are reported next to the human ones and have no breakdowns
+	report, err := Query(db, "abc", Options{From: now.AddDate(0, 0, -7), To: now})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), report.Total)
+	assert.Equal(t, int64(2), report.Bots)
+	assert.Equal(t, int64(1), report.Visitors)
+	assert.Equal(t, map[string]int64{"Chrome": 1}, report.Dimensions[DimensionBrowser])
+}
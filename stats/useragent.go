@@ -0,0 +1,75 @@
+package stats
+
+import "strings"
+
+// UserAgent is the browser family, device class and operating system of a
+// User-Agent header.
+type UserAgent struct {
+	Browser string
+	Device  string
+	OS      string
+}
+
+type uaRule struct {
+	name     string
+	contains []string
+}
+
+// rules are checked in order and the first match wins. Most browsers claim to
+// be several others, so the more specific tokens come first.
+var browserRules = []uaRule{
+	{"Edge", []string{"Edg/", "EdgA/", "EdgiOS/", "Edge/"}},
+	{"Opera", []string{"OPR/", "Opera"}},
+	{"Samsung Internet", []string{"SamsungBrowser/"}},
+	{"Chrome", []string{"CriOS/", "Chrome/", "Chromium/"}},
+	{"Firefox", []string{"FxiOS/", "Firefox/"}},
+	{"Safari", []string{"Safari/"}},
+	{"Internet Explorer", []string{"MSIE ", "Trident/"}},
+	{"curl", []string{"curl/"}},
+}
+
+var osRules = []uaRule{
+	{"iOS", []string{"iPhone", "iPad", "iPod"}},
+	{"Android", []string{"Android"}},
+	{"Windows", []string{"Windows"}},
+	{"ChromeOS", []string{"CrOS"}},
+	{"macOS", []string{"Macintosh", "Mac OS X"}},
+	{"Linux", []string{"Linux"}},
+}
+
+func matchRule(ua string, rules []uaRule) string {
+	for _, rule := range rules {
+		for _, token := range rule.contains {
+			if strings.Contains(ua, token) {
+				return rule.name
+			}
+		}
+	}
+	return "Other"
+}
+
+// ParseUserAgent classifies a User-Agent header. It only looks for well known
+// tokens, so uncommon clients are reported as "Other".
+func ParseUserAgent(ua string) UserAgent {
+	if ua == "" {
+		return UserAgent{Browser: Unknown, Device: Unknown, OS: Unknown}
+	}
+
+	agent := UserAgent{
+		Browser: matchRule(ua, browserRules),
+		OS:      matchRule(ua, osRules),
+	}
+
+	switch {
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		(agent.OS == "Android" && !strings.Contains(ua, "Mobile")):
+		agent.Device = "tablet"
+	case strings.Contains(ua, "Mobi") || agent.OS == "iOS" || agent.OS == "Android":
+		agent.Device = "mobile"
+	case agent.OS != "Other":
+		agent.Device = "desktop"
+	default:
+		agent.Device = "other"
+	}
+	return agent
+}
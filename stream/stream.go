@@ -0,0 +1,144 @@
+package stream
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EventClick = "click"
+
+	defaultBufferSize     = 64
+	defaultMaxSubscribers = 1000
+)
+
+var (
+	ErrTooManySubscribers = errors.New("stream.go: too many subscribers")
+	ErrClosed             = errors.New("stream.go: broker is closed")
+)
+
+// Event is a click or a lifecycle event of a short url.
+type Event struct {
+	Type   string    `json:"type"`
+	LinkID string    `json:"link_id"`
+	Time   time.Time `json:"time"`
+	Data   any       `json:"data,omitempty"`
+}
+
+// Subscription receives the events of one short url, or of every short url
+// when its link id is empty.
+type Subscription struct {
+	linkID  string
+	events  chan Event
+	dropped atomic.Bool
+}
+
+// Events is closed when the subscription ends, either because it was
+// unsubscribed, it fell behind or the broker was closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped reports whether the subscription was ended because its buffer was
+// full.
+func (s *Subscription) Dropped() bool {
+	return s.dropped.Load()
+}
+
+// Broker fans events out to subscribers in process. Publishing never blocks:
+// every subscriber has a bounded buffer and a subscriber whose buffer is full
+// is dropped so it can't hold back the redirects that publish.
+type Broker struct {
+	lock           sync.Mutex
+	bufferSize     int
+	maxSubscribers int
+	subs           map[*Subscription]struct{}
+	closed         bool
+}
+
+// NewBroker creates a broker. Zero values use the defaults.
+func NewBroker(bufferSize int, maxSubscribers int) *Broker {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	if maxSubscribers <= 0 {
+		maxSubscribers = defaultMaxSubscribers
+	}
+	return &Broker{
+		bufferSize:     bufferSize,
+		maxSubscribers: maxSubscribers,
+		subs:           make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe starts a subscription to the events of linkID, or to all events
+// when linkID is empty.
+func (b *Broker) Subscribe(linkID string) (*Subscription, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+	if len(b.subs) >= b.maxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+	sub := &Subscription{linkID: linkID, events: make(chan Event, b.bufferSize)}
+	b.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Unsubscribe ends a subscription. It is safe to call more than once and after
+// the subscription was dropped.
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.remove(sub)
+}
+
+// remove closes the subscription. The caller must hold the lock.
+func (b *Broker) remove(sub *Subscription) {
+	if _, ok := b.subs[sub]; !ok {
+		return
+	}
+	delete(b.subs, sub)
+	close(sub.events)
+}
+
+// Publish sends the event to every matching subscriber.
+func (b *Broker) Publish(event Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for sub := range b.subs {
+		if sub.linkID != "" && sub.linkID != event.LinkID {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped.Store(true)
+			b.remove(sub)
+		}
+	}
+}
+
+// Subscribers returns the number of active subscriptions.
+func (b *Broker) Subscribers() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.subs)
+}
+
+// Close ends every subscription and rejects new ones.
+func (b *Broker) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		b.remove(sub)
+	}
+}
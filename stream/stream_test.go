@@ -0,0 +1,102 @@
+package stream
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func drain(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestPublishFiltersByLink(t *testing.T) {
+	b := NewBroker(10, 10)
+	all, err := b.Subscribe("")
+	require.NoError(t, err)
+	one, err := b.Subscribe("abc")
+	require.NoError(t, err)
+
+	b.Publish(Event{Type: EventClick, LinkID: "abc", Time: time.Now()})
+	b.Publish(Event{Type: EventClick, LinkID: "xyz", Time: time.Now()})
+
+	assert.Len(t, drain(all), 2)
+	events := drain(one)
+	require.Len(t, events, 1)
+	assert.Equal(t, "abc", events[0].LinkID)
+}
+
+func TestSlowConsumerIsDropped(t *testing.T) {
+	b := NewBroker(2, 10)
+	slow, err := b.Subscribe("")
+	require.NoError(t, err)
+	fast, err := b.Subscribe("")
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		b.Publish(Event{Type: EventClick, LinkID: "abc"})
+		drain(fast)
+	}
+
+	assert.True(t, slow.Dropped())
+	assert.False(t, fast.Dropped())
+	assert.Equal(t, 1, b.Subscribers())
+
+	// the buffered events are still delivered before the channel is closed
+	assert.Len(t, drain(slow), 2)
+	_, ok := <-slow.Events()
+	assert.False(t, ok)
+}
+
+func TestSubscriberLimitAndClose(t *testing.T) {
+	b := NewBroker(1, 1)
+	sub, err := b.Subscribe("")
+	require.NoError(t, err)
+	_, err = b.Subscribe("")
+	assert.ErrorIs(t, err, ErrTooManySubscribers)
+
+	b.Unsubscribe(sub)
+	b.Unsubscribe(sub)
+	sub, err = b.Subscribe("")
+	require.NoError(t, err)
+
+	b.Close()
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+	assert.False(t, sub.Dropped())
+	_, err = b.Subscribe("")
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestConcurrentPublish(t *testing.T) {
+	b := NewBroker(1000, 10)
+	sub, err := b.Subscribe("")
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				b.Publish(Event{Type: EventClick, LinkID: "abc"})
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, drain(sub), 500)
+}
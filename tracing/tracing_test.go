@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/moh-osman3/shortener/config"
+)
+
+func TestNewExporter(t *testing.T) {
+	ctx := context.Background()
+
+	exporter, err := newExporter(ctx, config.TracingConfig{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, exporter)
+
+	exporter, err = newExporter(ctx, config.TracingConfig{Exporter: "otlp", Endpoint: "localhost:4318", Insecure: true}, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, exporter)
+
+	_, err = newExporter(ctx, config.TracingConfig{Exporter: "zipkin"}, nil)
+	assert.Error(t, err)
+}
+
+func TestStdoutExporter(t *testing.T) {
+	ctx := context.Background()
+	var out bytes.Buffer
+	exporter, err := newExporter(ctx, config.TracingConfig{Exporter: "stdout"}, &out)
+	require.NoError(t, err)
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	_, span := provider.Tracer(tracerName).Start(ctx, "test-span")
+	span.End()
+	require.NoError(t, provider.Shutdown(ctx))
+
+	assert.Contains(t, out.String(), "test-span")
+}
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.TracingConfig{})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup(context.Background(), config.TracingConfig{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
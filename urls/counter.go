@@ -77,6 +77,12 @@ func (c *Counter) AddCall(timestamp time.Time) {
 // timestamps if they occured in the last 7 days and then keep a simple count for total calls.
 // or replace this with an otel instrumentation?
 func (c *Counter) GetSummary() string {
+	dayTotal, weekTotal, allTotal := c.Totals()
+	return fmt.Sprintf("Summary of shorturl:\n calls in the last day: %d calls\n calls in the last week: %d calls\n total calls since creation: %d calls\n", dayTotal, weekTotal, allTotal)
+}
+
+// Totals returns the calls in the last day, the last week and all time.
+func (c *Counter) Totals() (int64, int64, int64) {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
 	nowTime := time.Now()
@@ -87,7 +93,7 @@ func (c *Counter) GetSummary() string {
 
 	for _, count := range c.WeekBuffer {
 		if nowSeconds-secondsInDay < count.lastUnix {
-			dayTotal = count.count
+			dayTotal += count.count
 		}
 
 		if nowSeconds-7*secondsInDay < count.lastUnix {
@@ -96,5 +102,5 @@ func (c *Counter) GetSummary() string {
 		}
 	}
 
-	return fmt.Sprintf("Summary of shorturl:\n calls in the last day: %d calls\n calls in the last week: %d calls\n total calls since creation: %d calls\n", dayTotal, weekTotal, allTotal)
+	return dayTotal, weekTotal, allTotal
 }
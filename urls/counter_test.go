@@ -59,3 +59,20 @@ func TestCounterEdge(t *testing.T) {
 
 	assert.Equal(t, expectedSummary, c.GetSummary())
 }
+
+func TestCounterDaySpansTwoBuckets(t *testing.T) {
+	c := NewCounter()
+
+	// the last 24 hours cover the end of yesterday (utc) and the start of
+	// today, which are kept in different buckets
+	now := time.Now()
+	todayStart := now.Truncate(24 * time.Hour)
+	c.AddCall(todayStart.Add(-time.Second))
+	c.AddCall(todayStart.Add(-time.Second))
+	c.AddCall(now)
+
+	day, week, total := c.Totals()
+	assert.Equal(t, int64(3), day)
+	assert.Equal(t, int64(3), week)
+	assert.Equal(t, int64(3), total)
+}
@@ -0,0 +1,76 @@
+package urls
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/moh-osman3/shortener/config"
+)
+
+const (
+	// Never is the expiry of short urls that don't expire
+	Never = "never"
+
+	// a year of 365 days
+	defaultExpiry = 365 * 24 * time.Hour
+)
+
+// ParseDuration parses durations with the units d, w and y on top of the ones
+// of time.ParseDuration, see config.ParseDuration.
+func ParseDuration(value string) (time.Duration, error) {
+	return config.ParseDuration(value)
+}
+
+// ResolveExpiry turns the expiry of a create request into the time the short
+// url expires, the zero time meaning never. expiry is a duration from now (see
+// ParseDuration) or "never", expiresAt an RFC 3339 timestamp. At most one of
+// them may be set; when neither is the configured default applies. Expiries
+// past the configured maximum are rejected.
+func ResolveExpiry(expiry string, expiresAt string, now time.Time, cfg config.ExpiryConfig) (time.Time, error) {
+	if expiry != "" && expiresAt != "" {
+		return time.Time{}, errors.New("expiry.go: set either expiry or expires_at, not both")
+	}
+
+	var at time.Time
+	switch {
+	case strings.EqualFold(expiry, Never):
+		if cfg.Max > 0 {
+			return time.Time{}, fmt.Errorf("expiry.go: short urls must expire within %s", time.Duration(cfg.Max))
+		}
+		return time.Time{}, nil
+	case expiry != "":
+		d, err := ParseDuration(expiry)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("expiry.go: expiry must be positive, use %q for short urls that don't expire", Never)
+		}
+		at = now.Add(d)
+	case expiresAt != "":
+		parsed, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("expiry.go: invalid expires_at %q, expected RFC 3339", expiresAt)
+		}
+		if !parsed.After(now) {
+			return time.Time{}, errors.New("expiry.go: expires_at must be in the future")
+		}
+		at = parsed
+	default:
+		d := time.Duration(cfg.Default)
+		if d <= 0 {
+			d = defaultExpiry
+		}
+		if cfg.Max > 0 && d > time.Duration(cfg.Max) {
+			d = time.Duration(cfg.Max)
+		}
+		return now.Add(d), nil
+	}
+
+	if cfg.Max > 0 && at.Sub(now) > time.Duration(cfg.Max) {
+		return time.Time{}, fmt.Errorf("expiry.go: short urls must expire within %s", time.Duration(cfg.Max))
+	}
+	return at, nil
+}
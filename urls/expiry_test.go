@@ -0,0 +1,69 @@
+package urls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/moh-osman3/shortener/config"
+)
+
+func TestResolveExpiry(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	cfg := config.ExpiryConfig{Default: config.Duration(30 * 24 * time.Hour)}
+
+	at, err := ResolveExpiry("10d", "", now, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 10), at)
+
+	at, err = ResolveExpiry("", "2025-04-01T00:00:00Z", now, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), at)
+
+	at, err = ResolveExpiry("never", "", now, cfg)
+	require.NoError(t, err)
+	assert.True(t, at.IsZero())
+
+	// the default applies when neither is set
+	at, err = ResolveExpiry("", "", now, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 30), at)
+	at, err = ResolveExpiry("", "", now, config.ExpiryConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(365*24*time.Hour), at)
+
+	for _, tc := range []struct{ expiry, expiresAt string }{
+		{"10d", "2025-04-01T00:00:00Z"},
+		{"0s", ""},
+		{"-3s", ""},
+		{"wrongtype", ""},
+		{"", "tomorrow"},
+		{"", "2025-03-01T00:00:00Z"},
+	} {
+		_, err := ResolveExpiry(tc.expiry, tc.expiresAt, now, cfg)
+		assert.Error(t, err, tc)
+	}
+}
+
+func TestResolveExpiryMax(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	cfg := config.ExpiryConfig{Default: config.Duration(365 * 24 * time.Hour), Max: config.Duration(90 * 24 * time.Hour)}
+
+	_, err := ResolveExpiry("never", "", now, cfg)
+	assert.Error(t, err)
+	_, err = ResolveExpiry("91d", "", now, cfg)
+	assert.Error(t, err)
+	_, err = ResolveExpiry("", "2026-01-01T00:00:00Z", now, cfg)
+	assert.Error(t, err)
+
+	at, err := ResolveExpiry("90d", "", now, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 90), at)
+
+	// a default past the maximum is capped
+	at, err = ResolveExpiry("", "", now, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 90), at)
+}
@@ -0,0 +1,62 @@
+package urls
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+const (
+	// the destination's own query parameters win over forwarded ones with the
+	// same name, the default
+	QueryPrecedenceDestination = "destination"
+	// forwarded query parameters replace the destination's ones with the same
+	// name
+	QueryPrecedenceRequest = "request"
+)
+
+var errInvalidSuffix = errors.New("forward.go: path suffix must not contain . or .. segments")
+
+// Forward appends an escaped path suffix like "/v2/intro" to the path of the
+// long url and merges the query parameters into its query string, with
+// precedence deciding which side wins for parameters set on both.
+func Forward(longUrl string, suffix string, query url.Values, precedence string) (string, error) {
+	if suffix == "" && len(query) == 0 {
+		return longUrl, nil
+	}
+	u, err := url.Parse(longUrl)
+	if err != nil {
+		return "", err
+	}
+
+	if suffix != "" {
+		// dot segments would let the suffix climb out of the long url's path
+		for _, segment := range strings.Split(suffix, "/") {
+			unescaped, err := url.PathUnescape(segment)
+			if err != nil {
+				return "", err
+			}
+			if unescaped == "." || unescaped == ".." {
+				return "", errInvalidSuffix
+			}
+		}
+		escaped := strings.TrimSuffix(u.EscapedPath(), "/") + suffix
+		path, err := url.PathUnescape(escaped)
+		if err != nil {
+			return "", err
+		}
+		u.Path, u.RawPath = path, escaped
+	}
+
+	if len(query) > 0 {
+		values := u.Query()
+		for key, forwarded := range query {
+			if _, ok := values[key]; ok && precedence != QueryPrecedenceRequest {
+				continue
+			}
+			values[key] = forwarded
+		}
+		u.RawQuery = values.Encode()
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,43 @@
+package urls
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForward(t *testing.T) {
+	tests := []struct {
+		longUrl    string
+		suffix     string
+		query      string
+		precedence string
+		expected   string
+	}{
+		{"https://example.com/docs", "", "", "", "https://example.com/docs"},
+		{"https://example.com/docs", "/v2/intro", "", "", "https://example.com/docs/v2/intro"},
+		{"https://example.com/docs/", "/v2/intro", "", "", "https://example.com/docs/v2/intro"},
+		{"https://example.com/", "/a%2Fb/c%20d", "", "", "https://example.com/a%2Fb/c%20d"},
+		{"https://example.com/docs#top", "/v2", "", "", "https://example.com/docs/v2#top"},
+		{"https://example.com/?utm_source=mail", "", "ref=abc", "", "https://example.com/?ref=abc&utm_source=mail"},
+		{"https://example.com/?utm_source=mail", "", "utm_source=ads", "", "https://example.com/?utm_source=mail"},
+		{"https://example.com/?utm_source=mail", "", "utm_source=ads", QueryPrecedenceDestination, "https://example.com/?utm_source=mail"},
+		{"https://example.com/?utm_source=mail", "", "utm_source=ads&ref=abc", QueryPrecedenceRequest, "https://example.com/?ref=abc&utm_source=ads"},
+		{"https://example.com/docs?lang=en", "/v2", "page=2", "", "https://example.com/docs/v2?lang=en&page=2"},
+	}
+
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		require.NoError(t, err)
+		out, err := Forward(test.longUrl, test.suffix, query, test.precedence)
+		assert.NoError(t, err, test)
+		assert.Equal(t, test.expected, out, test)
+	}
+
+	for _, suffix := range []string{"/../admin", "/v2/./intro", "/%2e%2e/admin", "/%zz"} {
+		_, err := Forward("https://example.com/docs", suffix, nil, "")
+		assert.Error(t, err, suffix)
+	}
+}
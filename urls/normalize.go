@@ -0,0 +1,142 @@
+package urls
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+
+	"github.com/moh-osman3/shortener/config"
+)
+
+const (
+	defaultScheme    = "https"
+	defaultMaxLength = 2048
+)
+
+var (
+	defaultAllowedSchemes = []string{"http", "https"}
+	defaultPorts          = map[string]string{"http": "80", "https": "443"}
+
+	// matches an explicit scheme like "https:" or "javascript:"
+	schemeRegexp = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*):`)
+)
+
+// Normalize validates a destination url and returns its canonical form so that
+// equivalent urls are stored the same way. Urls without a scheme get the
+// configured default scheme, the scheme must be in the allowlist, the host is
+// lowercased and converted to punycode, and default ports are dropped.
+func Normalize(rawUrl string, cfg config.URLConfig) (string, error) {
+	maxLength := cfg.MaxLength
+	if maxLength <= 0 {
+		maxLength = defaultMaxLength
+	}
+	scheme := cfg.DefaultScheme
+	if scheme == "" {
+		scheme = defaultScheme
+	}
+	allowed := cfg.AllowedSchemes
+	if len(allowed) == 0 {
+		allowed = defaultAllowedSchemes
+	}
+
+	rawUrl = strings.TrimSpace(rawUrl)
+	if rawUrl == "" {
+		return "", errors.New("normalize.go: url is empty")
+	}
+	if len(rawUrl) > maxLength {
+		return "", fmt.Errorf("normalize.go: url is longer than %d characters", maxLength)
+	}
+
+	if !hasScheme(rawUrl) {
+		rawUrl = scheme + "://" + rawUrl
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", fmt.Errorf("normalize.go: invalid url: %w", err)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !contains(allowed, u.Scheme) {
+		return "", fmt.Errorf("normalize.go: scheme %q is not allowed", u.Scheme)
+	}
+	if u.Opaque != "" || u.Host == "" {
+		return "", errors.New("normalize.go: url must have a host")
+	}
+	if u.User != nil {
+		return "", errors.New("normalize.go: urls with credentials are not allowed")
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if net.ParseIP(host) == nil {
+		host, err = idna.Lookup.ToASCII(strings.ToLower(strings.TrimSuffix(host, ".")))
+		if err != nil {
+			return "", fmt.Errorf("normalize.go: invalid host: %w", err)
+		}
+	}
+
+	if port != "" {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return "", fmt.Errorf("normalize.go: invalid port %q", port)
+		}
+		if defaultPorts[u.Scheme] == port {
+			port = ""
+		}
+	}
+
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// ipv6 literal
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	normalized := u.String()
+	if len(normalized) > maxLength {
+		return "", fmt.Errorf("normalize.go: url is longer than %d characters", maxLength)
+	}
+	return normalized, nil
+}
+
+// hasScheme reports whether the url starts with a scheme. "localhost:8080/path"
+// is treated as a host and port rather than a "localhost" scheme.
+func hasScheme(rawUrl string) bool {
+	match := schemeRegexp.FindStringSubmatch(rawUrl)
+	if match == nil {
+		return false
+	}
+	if strings.HasPrefix(rawUrl[len(match[0]):], "//") {
+		return true
+	}
+
+	rest := rawUrl[len(match[0]):]
+	end := strings.IndexAny(rest, "/?#")
+	if end == -1 {
+		end = len(rest)
+	}
+	if _, err := strconv.Atoi(rest[:end]); err == nil && end > 0 {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
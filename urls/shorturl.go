@@ -12,19 +12,79 @@ type ShortUrl interface {
 	GetId() string
 	GetLongUrl() string
 	GetExpiry() time.Time
+	SetExpiry(expiresAt time.Time)
+	GetCreationTime() time.Time
+	GetActiveFrom() time.Time
+	SetActiveFrom(activeFrom time.Time)
+	GetOptions() Options
+	SetOptions(options Options)
 	AddCall(timestamp time.Time)
 	GetSummary() string
+	GetCounter() *Counter
+	GetDisabled() *DisabledState
+	SetDisabled(state *DisabledState)
 	Marshal() ([]byte, error)
 	Unmarshal([]byte) error
 }
 
+// DisabledState records why a short url was taken down. Disabled short urls
+// are kept in storage so their id is never reused and their stats stay around.
+type DisabledState struct {
+	Reason string `json:"reason"`
+	// legal takedowns are served as 451 Unavailable For Legal Reasons,
+	// everything else as 410 Gone
+	Legal     bool      `json:"legal"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Options are the optional settings of a short url chosen on create.
+type Options struct {
+	// the short url expires once it hasn't been resolved for this long, 0
+	// turns idle expiry off
+	IdleTTL time.Duration `json:"idle_ttl,omitempty"`
+	// the short url can be resolved this many times before it returns 410
+	// Gone, 0 means no limit
+	MaxClicks int `json:"max_clicks,omitempty"`
+	// move the short url to the trash once its last click is used up
+	DeleteAfterMaxClicks bool `json:"delete_after_max_clicks,omitempty"`
+	// where the short url redirects before its active_from, the not yet
+	// available page is served when empty
+	PrelaunchUrl string `json:"prelaunch_url,omitempty"`
+	// where the short url redirects once it expired or used up its clicks
+	FallbackUrl string `json:"fallback_url,omitempty"`
+	// sha256 of the api key that created the short url, which lets its
+	// holder renew it
+	Owner string `json:"owner,omitempty"`
+	// salted bcrypt hash of the password asked before redirecting, no
+	// password when empty
+	PasswordHash string `json:"password_hash,omitempty"`
+	// only redirect requests with a valid access token in the query string
+	Signed bool `json:"signed,omitempty"`
+	// append the path after the id of a redirect to the long url's path
+	ForwardPath bool `json:"forward_path,omitempty"`
+	// merge the query string of a redirect into the long url's
+	ForwardQuery bool `json:"forward_query,omitempty"`
+	// which side wins for query parameters set on both, QueryPrecedenceDestination
+	// when empty
+	QueryPrecedence string `json:"query_precedence,omitempty"`
+}
+
 type defaultShortUrl struct {
 	// export these fields for json marshaling
 	Id           string    `json:"id"`
 	LongUrl      string    `json:"long_url"`
 	Expiry       time.Time `json:"expiry"`
 	CreationTime time.Time `json:"creation_time"`
-	Counter      *Counter  `json:"counter"`
+	// the short url doesn't redirect before this time, the zero time means
+	// it is active right away
+	ActiveFrom time.Time `json:"active_from"`
+	// click counts are stored separately in the stats keyspace. Counter is
+	// only set on records written before that, so their old counts can still
+	// be merged into summaries.
+	Counter *Counter `json:"counter,omitempty"`
+	// nil unless the short url has been disabled
+	Disabled *DisabledState `json:"disabled,omitempty"`
+	Options  Options        `json:"options"`
 }
 
 func (su *defaultShortUrl) Marshal() ([]byte, error) {
@@ -36,30 +96,32 @@ func (su *defaultShortUrl) Unmarshal(data []byte) error {
 }
 
 func (su *defaultShortUrl) AddCall(timestamp time.Time) {
+	if su.Counter == nil {
+		su.Counter = NewCounter()
+	}
 	su.Counter.AddCall(timestamp)
 }
 
 func (su *defaultShortUrl) GetSummary() string {
+	if su.Counter == nil {
+		return NewCounter().GetSummary()
+	}
 	return su.Counter.GetSummary()
 }
 
-func NewDefaultShortUrl(id string, longUrl string, expiry time.Duration, timestamp time.Time) ShortUrl {
-	su := &defaultShortUrl{
+func (su *defaultShortUrl) GetCounter() *Counter {
+	return su.Counter
+}
+
+// NewDefaultShortUrl creates a short url that expires at expiresAt. The zero
+// time means it never expires, see ResolveExpiry.
+func NewDefaultShortUrl(id string, longUrl string, expiresAt time.Time, timestamp time.Time) ShortUrl {
+	return &defaultShortUrl{
 		Id:           id,
 		LongUrl:      longUrl,
+		Expiry:       expiresAt,
 		CreationTime: timestamp,
-		Counter:      NewCounter(),
 	}
-
-	if expiry == 0 {
-		// default behavior
-		su.Expiry = timestamp.AddDate(1, 0, 0)
-	} else if expiry < 0 {
-		su.Expiry = time.Time{}
-	} else {
-		su.Expiry = timestamp.Add(expiry)
-	}
-	return su
 }
 
 func (su *defaultShortUrl) GetId() string {
@@ -70,6 +132,62 @@ func (su *defaultShortUrl) GetExpiry() time.Time {
 	return su.Expiry
 }
 
+func (su *defaultShortUrl) SetExpiry(expiresAt time.Time) {
+	su.Expiry = expiresAt
+}
+
+func (su *defaultShortUrl) GetCreationTime() time.Time {
+	return su.CreationTime
+}
+
+func (su *defaultShortUrl) GetActiveFrom() time.Time {
+	return su.ActiveFrom
+}
+
+func (su *defaultShortUrl) SetActiveFrom(activeFrom time.Time) {
+	su.ActiveFrom = activeFrom
+}
+
+// IsActive reports whether the short url redirects at now, i.e. its
+// active_from has passed or it has none.
+func IsActive(su ShortUrl, now time.Time) bool {
+	return !now.Before(su.GetActiveFrom())
+}
+
+func (su *defaultShortUrl) GetOptions() Options {
+	return su.Options
+}
+
+func (su *defaultShortUrl) SetOptions(options Options) {
+	su.Options = options
+}
+
+// IdleExpiry returns when the short url expires for lack of use given the last
+// time it was resolved, or the zero time when it has no idle ttl. Short urls
+// that were never resolved count from their creation, or from their
+// active_from when they were scheduled.
+func IdleExpiry(su ShortUrl, lastAccess time.Time) time.Time {
+	ttl := su.GetOptions().IdleTTL
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	if lastAccess.Before(su.GetCreationTime()) {
+		lastAccess = su.GetCreationTime()
+	}
+	if lastAccess.Before(su.GetActiveFrom()) {
+		lastAccess = su.GetActiveFrom()
+	}
+	return lastAccess.Add(ttl)
+}
+
 func (su *defaultShortUrl) GetLongUrl() string {
 	return su.LongUrl
 }
+
+func (su *defaultShortUrl) GetDisabled() *DisabledState {
+	return su.Disabled
+}
+
+func (su *defaultShortUrl) SetDisabled(state *DisabledState) {
+	su.Disabled = state
+}
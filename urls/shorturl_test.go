@@ -13,7 +13,7 @@ func TestShortUrl(t *testing.T) {
 	longUrl := "www.longurl.com"
 	expiry := 5 * time.Minute
 	timestamp := time.Now()
-	surl := NewDefaultShortUrl(id, longUrl, expiry, timestamp)
+	surl := NewDefaultShortUrl(id, longUrl, timestamp.Add(expiry), timestamp)
 
 	surl.AddCall(time.Now())
 	expectedSummary := fmt.Sprintf("Summary of shorturl:\n calls in the last day: %d calls\n calls in the last week: %d calls\n total calls since creation: %d calls\n", 1, 1, 1)
@@ -28,33 +28,26 @@ func TestExpiry(t *testing.T) {
 	timestamp := time.Now()
 	id := "hashid"
 	longUrl := "www.longurl.com"
-	expiry := 5 * time.Minute
-	surl := NewDefaultShortUrl(id, longUrl, expiry, timestamp)
-	assert.Equal(t, timestamp.Add(expiry), surl.GetExpiry())
-
-	timestamp = time.Now()
-	expiry = -1 * time.Minute
-	surl = NewDefaultShortUrl(id, longUrl, expiry, timestamp)
-	assert.Equal(t, time.Time{}, surl.GetExpiry())
+	expiresAt := timestamp.Add(5 * time.Minute)
+	surl := NewDefaultShortUrl(id, longUrl, expiresAt, timestamp)
+	assert.Equal(t, expiresAt, surl.GetExpiry())
 
-	timestamp = time.Now()
-	expiry = 0 * time.Minute
-	surl = NewDefaultShortUrl(id, longUrl, expiry, timestamp)
-	assert.Equal(t, timestamp.AddDate(1, 0, 0), surl.GetExpiry())
+	// the zero time never expires
+	surl = NewDefaultShortUrl(id, longUrl, time.Time{}, timestamp)
+	assert.True(t, surl.GetExpiry().IsZero())
 }
 
 func TestMarshal(t *testing.T) {
 	timestamp := time.Now()
 	id := "hashid"
 	longUrl := "www.longurl.com"
-	expiry := 5 * time.Minute
-	surl := NewDefaultShortUrl(id, longUrl, expiry, timestamp)
+	surl := NewDefaultShortUrl(id, longUrl, timestamp.Add(5*time.Minute), timestamp)
 	surl.AddCall(time.Now())
 
 	out, err := surl.Marshal()
 	assert.NoError(t, err)
 
-	unmarshaledSurl := NewDefaultShortUrl("", "", time.Second, time.Now())
+	unmarshaledSurl := NewDefaultShortUrl("", "", time.Time{}, time.Now())
 	err = unmarshaledSurl.Unmarshal(out)
 	assert.NoError(t, err)
 	assert.Equal(t, surl.GetId(), unmarshaledSurl.GetId())
@@ -62,3 +55,57 @@ func TestMarshal(t *testing.T) {
 	assert.Equal(t, surl.GetExpiry().Unix(), unmarshaledSurl.GetExpiry().Unix())
 	assert.Equal(t, surl.GetSummary(), unmarshaledSurl.GetSummary())
 }
+
+func TestDisabled(t *testing.T) {
+	surl := NewDefaultShortUrl("hashid", "www.longurl.com", time.Now().Add(5*time.Minute), time.Now())
+	assert.Nil(t, surl.GetDisabled())
+
+	state := &DisabledState{Reason: "phishing", Legal: true, Timestamp: time.Now()}
+	surl.SetDisabled(state)
+	assert.Equal(t, state, surl.GetDisabled())
+
+	out, err := surl.Marshal()
+	assert.NoError(t, err)
+
+	unmarshaledSurl := NewDefaultShortUrl("", "", time.Time{}, time.Now())
+	err = unmarshaledSurl.Unmarshal(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "phishing", unmarshaledSurl.GetDisabled().Reason)
+	assert.True(t, unmarshaledSurl.GetDisabled().Legal)
+
+	surl.SetDisabled(nil)
+	assert.Nil(t, surl.GetDisabled())
+}
+
+func TestIdleExpiry(t *testing.T) {
+	created := time.Now().Add(-time.Hour)
+	surl := NewDefaultShortUrl("hashid", "www.longurl.com", time.Time{}, created)
+	assert.True(t, IdleExpiry(surl, time.Now()).IsZero())
+
+	surl.SetOptions(Options{IdleTTL: 2 * time.Hour})
+	// never accessed, idle since creation
+	assert.Equal(t, created.Add(2*time.Hour), IdleExpiry(surl, time.Time{}))
+	accessed := time.Now()
+	assert.Equal(t, accessed.Add(2*time.Hour), IdleExpiry(surl, accessed))
+
+	out, err := surl.Marshal()
+	assert.NoError(t, err)
+	unmarshaledSurl := NewDefaultShortUrl("", "", time.Time{}, time.Now())
+	assert.NoError(t, unmarshaledSurl.Unmarshal(out))
+	assert.Equal(t, 2*time.Hour, unmarshaledSurl.GetOptions().IdleTTL)
+}
+
+func TestActiveFrom(t *testing.T) {
+	now := time.Now()
+	surl := NewDefaultShortUrl("hashid", "www.longurl.com", time.Time{}, now)
+	assert.True(t, IsActive(surl, now))
+
+	launch := now.Add(48 * time.Hour)
+	surl.SetActiveFrom(launch)
+	assert.False(t, IsActive(surl, now))
+	assert.True(t, IsActive(surl, launch))
+
+	// scheduled short urls are not idle before they launch
+	surl.SetOptions(Options{IdleTTL: time.Hour})
+	assert.Equal(t, launch.Add(time.Hour), IdleExpiry(surl, time.Time{}))
+}
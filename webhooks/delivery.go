@@ -0,0 +1,363 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.uber.org/zap"
+)
+
+const (
+	OutcomeDelivered = "delivered"
+	OutcomeRetrying  = "retrying"
+	OutcomeDead      = "dead"
+)
+
+// LogEntry records one delivery attempt.
+type LogEntry struct {
+	DeliveryID     string    `json:"delivery_id"`
+	SubscriptionID string    `json:"subscription_id"`
+	EventID        string    `json:"event_id"`
+	EventType      string    `json:"event_type"`
+	Attempt        int       `json:"attempt"`
+	Time           time.Time `json:"time"`
+	StatusCode     int       `json:"status_code,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Outcome        string    `json:"outcome"`
+}
+
+func logKey(subID string, t time.Time, deliveryID string, attempt int) []byte {
+	return []byte(fmt.Sprintf("%s%s:%019d:%s:%d", logPrefix, subID, t.UnixNano(), deliveryID, attempt))
+}
+
+// logKeyTime extracts the attempt time from a log key.
+func logKeyTime(key []byte) (time.Time, bool) {
+	parts := strings.Split(strings.TrimPrefix(string(key), logPrefix), ":")
+	if len(parts) != 4 {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// backoff returns the wait after the given number of failed attempts:
+// InitialBackoff doubled for every attempt after the first, capped at
+// MaxBackoff.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := time.Duration(d.cfg.InitialBackoff)
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= time.Duration(d.cfg.MaxBackoff) {
+			return time.Duration(d.cfg.MaxBackoff)
+		}
+	}
+	return min(wait, time.Duration(d.cfg.MaxBackoff))
+}
+
+// Run delivers queued events until stop is closed. Closing stop also cancels
+// the delivery in flight, it stays queued and is sent again on the next start.
+func (d *Dispatcher) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(time.Duration(d.cfg.PollInterval))
+	defer ticker.Stop()
+	for {
+		d.ProcessQueue(ctx)
+		d.maybePruneLog()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+type pending struct {
+	key      []byte
+	delivery Delivery
+}
+
+// ProcessQueue sends the deliveries that are due, until ctx is cancelled. The
+// queue is only locked while the due deliveries are read and while the result
+// of each one is written, not while they are sent.
+func (d *Dispatcher) ProcessQueue(ctx context.Context) {
+	d.running.Lock()
+	defer d.running.Unlock()
+
+	due, err := d.dueDeliveries()
+	if err != nil {
+		d.logger.Debug("delivery.go: failed to read webhook queue", zap.Error(err))
+		return
+	}
+
+	for _, p := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := d.attempt(ctx, p.key, p.delivery); err != nil {
+			d.logger.Debug("delivery.go: failed to update webhook queue", zap.String("delivery", p.delivery.ID), zap.Error(err))
+		}
+	}
+}
+
+// dueDeliveries reads up to maxDeliveriesPerPoll deliveries that are due.
+func (d *Dispatcher) dueDeliveries() ([]pending, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var due []pending
+
+	now := d.now()
+	limit := []byte(fmt.Sprintf("%s%019d;", queuePrefix, now.UnixNano()))
+	iter := d.db.NewIterator(&util.Range{Start: []byte(queuePrefix), Limit: limit}, nil)
+	for iter.Next() && len(due) < maxDeliveriesPerPoll {
+		var delivery Delivery
+		key := append([]byte{}, iter.Key()...)
+		if err := json.Unmarshal(iter.Value(), &delivery); err != nil {
+			d.logger.Debug("delivery.go: dropping unreadable delivery", zap.Error(err))
+			d.db.Delete(key, nil)
+			continue
+		}
+		due = append(due, pending{key: key, delivery: delivery})
+	}
+	iter.Release()
+	return due, iter.Error()
+}
+
+// attempt sends a delivery and moves it to the log, back on the queue or to
+// the dead letters depending on the result. A send cut short by ctx doesn't
+// count as an attempt and leaves the delivery queued.
+func (d *Dispatcher) attempt(ctx context.Context, key []byte, delivery Delivery) error {
+	sub, err := d.getSubscription(delivery.SubscriptionID)
+	if errors.Is(err, ErrNotFound) {
+		return d.db.Delete(key, nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	delivery.Attempts++
+	status, sendErr := d.send(ctx, sub, delivery)
+	if ctx.Err() != nil {
+		return nil
+	}
+	now := d.now()
+	entry := LogEntry{
+		DeliveryID:     delivery.ID,
+		SubscriptionID: sub.ID,
+		EventID:        delivery.Event.ID,
+		EventType:      delivery.Event.Type,
+		Attempt:        delivery.Attempts,
+		Time:           now,
+		StatusCode:     status,
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Delete(key)
+	switch {
+	case sendErr == nil:
+		entry.Outcome = OutcomeDelivered
+	case delivery.Attempts >= d.cfg.MaxAttempts:
+		entry.Outcome = OutcomeDead
+		entry.Error = sendErr.Error()
+		delivery.LastError = sendErr.Error()
+		val, err := json.Marshal(delivery)
+		if err != nil {
+			return err
+		}
+		batch.Put(deadKey(delivery.ID), val)
+		d.logger.Info("delivery.go: webhook delivery dead-lettered", zap.String("delivery", delivery.ID), zap.String("url", sub.URL), zap.Error(sendErr))
+	default:
+		entry.Outcome = OutcomeRetrying
+		entry.Error = sendErr.Error()
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttempt = now.Add(d.backoff(delivery.Attempts))
+		val, err := json.Marshal(delivery)
+		if err != nil {
+			return err
+		}
+		batch.Put(queueKey(delivery.NextAttempt, delivery.ID), val)
+	}
+
+	val, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	batch.Put(logKey(sub.ID, now, delivery.ID, delivery.Attempts), val)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.db.Write(batch, nil)
+}
+
+// send posts the event to the subscription. Any non 2xx response is a failure.
+func (d *Dispatcher) send(ctx context.Context, sub Subscription, delivery Delivery) (int, error) {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "shortener-webhooks/1")
+	req.Header.Set("X-Shortener-Event", delivery.Event.Type)
+	req.Header.Set("X-Shortener-Delivery", delivery.ID)
+	req.Header.Set(SignatureHeader, Sign(sub.Secret, d.now(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("delivery.go: receiver responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Deliveries returns the most recent delivery attempts, newest first. An empty
+// subID returns the attempts of every subscription.
+func (d *Dispatcher) Deliveries(subID string, limit int) ([]LogEntry, error) {
+	prefix := logPrefix
+	if subID != "" {
+		prefix += subID + ":"
+	}
+
+	entries := []LogEntry{}
+	iter := d.db.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var entry LogEntry
+		if err := json.Unmarshal(iter.Value(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// DeadLetters returns the deliveries that ran out of attempts.
+func (d *Dispatcher) DeadLetters() ([]Delivery, error) {
+	dead := []Delivery{}
+	iter := d.db.NewIterator(util.BytesPrefix([]byte(deadPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var delivery Delivery
+		if err := json.Unmarshal(iter.Value(), &delivery); err != nil {
+			continue
+		}
+		dead = append(dead, delivery)
+	}
+	return dead, iter.Error()
+}
+
+// Redeliver moves a dead letter back on the queue with a fresh set of
+// attempts.
+func (d *Dispatcher) Redeliver(id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	val, err := d.db.Get(deadKey(id), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var delivery Delivery
+	if err := json.Unmarshal(val, &delivery); err != nil {
+		return err
+	}
+
+	delivery.Attempts = 0
+	delivery.NextAttempt = d.now()
+	val, err = json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Delete(deadKey(id))
+	batch.Put(queueKey(delivery.NextAttempt, delivery.ID), val)
+	if err := d.db.Write(batch, nil); err != nil {
+		return err
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// PruneLog removes delivery log entries older than the log retention and
+// returns how many were removed.
+func (d *Dispatcher) PruneLog() (int, error) {
+	cutoff := d.now().Add(-time.Duration(d.cfg.LogRetention))
+	batch := new(leveldb.Batch)
+	iter := d.db.NewIterator(util.BytesPrefix([]byte(logPrefix)), nil)
+	for iter.Next() {
+		t, ok := logKeyTime(iter.Key())
+		if ok && t.Before(cutoff) {
+			batch.Delete(append([]byte{}, iter.Key()...))
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return 0, err
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+	return batch.Len(), d.db.Write(batch, nil)
+}
+
+func (d *Dispatcher) maybePruneLog() {
+	now := d.now()
+	if now.Sub(d.lastPrune) < logPruneInterval {
+		return
+	}
+	d.lastPrune = now
+	if pruned, err := d.PruneLog(); err != nil {
+		d.logger.Debug("delivery.go: failed to prune webhook log", zap.Error(err))
+	} else if pruned > 0 {
+		d.logger.Debug("delivery.go: pruned webhook log", zap.Int("entries", pruned))
+	}
+}
@@ -0,0 +1,66 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the timestamp and the HMAC-SHA256 of a delivery as
+// "t=<unix seconds>,v1=<hex digest>". The digest covers "<t>.<body>" so a
+// captured payload can't be replayed with a new timestamp.
+const SignatureHeader = "X-Shortener-Signature"
+
+var ErrInvalidSignature = errors.New("signature.go: invalid webhook signature")
+
+func digest(secret string, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Sign returns the signature header value for a payload sent at t.
+func Sign(secret string, t time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(digest(secret, t.Unix(), body)))
+}
+
+// Verify checks a signature header against the payload. Signatures older than
+// tolerance are rejected, a tolerance of 0 skips the check.
+func Verify(secret string, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp int64
+	var signature []byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			parsed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			timestamp = parsed
+		case "v1":
+			decoded, err := hex.DecodeString(value)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			signature = decoded
+		}
+	}
+	if timestamp == 0 || signature == nil {
+		return ErrInvalidSignature
+	}
+
+	if tolerance > 0 && now.Sub(time.Unix(timestamp, 0)) > tolerance {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal(signature, digest(secret, timestamp, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/config"
+)
+
+const (
+	EventCreated  = "link.created"
+	EventUpdated  = "link.updated"
+	EventDeleted  = "link.deleted"
+	EventDisabled = "link.disabled"
+	EventExpired  = "link.expired"
+)
+
+// Events lists the event types a subscription can ask for.
+var Events = []string{EventCreated, EventUpdated, EventDeleted, EventDisabled, EventExpired}
+
+// Subscriptions, the delivery queue, dead letters and the delivery log are
+// kept in the db next to the short urls:
+//
+//	webhook:sub:<id>                                     -> subscription
+//	webhook:queue:<next attempt unix nano>:<delivery id> -> pending delivery
+//	webhook:dead:<delivery id>                           -> delivery that ran out of attempts
+//	webhook:log:<sub id>:<unix nano>:<delivery id>       -> one delivery attempt
+//
+// Queue keys sort by the time of the next attempt so due deliveries are at the
+// start of the queue.
+const (
+	subPrefix   = "webhook:sub:"
+	queuePrefix = "webhook:queue:"
+	deadPrefix  = "webhook:dead:"
+	logPrefix   = "webhook:log:"
+
+	defaultMaxAttempts    = 8
+	defaultInitialBackoff = 10 * time.Second
+	defaultMaxBackoff     = time.Hour
+	defaultTimeout        = 10 * time.Second
+	defaultPollInterval   = time.Second
+	defaultLogRetention   = 7 * 24 * time.Hour
+
+	// bounds how many due deliveries are sent per poll
+	maxDeliveriesPerPoll = 100
+	logPruneInterval     = time.Hour
+)
+
+var ErrNotFound = errors.New("webhooks.go: not found")
+
+// DB is the subset of leveldb the dispatcher needs.
+type DB interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+	Put(key, value []byte, wo *opt.WriteOptions) error
+	Delete(key []byte, wo *opt.WriteOptions) error
+	NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator
+	Write(batch *leveldb.Batch, wo *opt.WriteOptions) error
+}
+
+// Subscription sends the events in Events, or every event when it is empty,
+// to URL. Payloads are signed with Secret.
+type Subscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s Subscription) wants(event string) bool {
+	return len(s.Events) == 0 || slices.Contains(s.Events, event)
+}
+
+// Event is the payload posted to subscribers.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Delivery is an event on its way to one subscription.
+type Delivery struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscription_id"`
+	Event          Event     `json:"event"`
+	Attempts       int       `json:"attempts"`
+	NextAttempt    time.Time `json:"next_attempt"`
+	LastError      string    `json:"last_error,omitempty"`
+}
+
+// Dispatcher stores subscriptions and delivers events to them in the
+// background, retrying failed deliveries with exponential backoff.
+type Dispatcher struct {
+	db     DB
+	cfg    config.WebhooksConfig
+	client *http.Client
+	logger *zap.Logger
+	now    func() time.Time
+
+	// one queue run at a time, so a delivery isn't sent twice
+	running sync.Mutex
+	// serializes reads and writes of the queue by queue runs with changes
+	// made by the api. It isn't held while deliveries are sent.
+	lock      sync.Mutex
+	wake      chan struct{}
+	lastPrune time.Time
+}
+
+// New creates a dispatcher. Zero config values use the defaults.
+func New(db DB, cfg config.WebhooksConfig, logger *zap.Logger) *Dispatcher {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = config.Duration(defaultInitialBackoff)
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = config.Duration(defaultMaxBackoff)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = config.Duration(defaultTimeout)
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = config.Duration(defaultPollInterval)
+	}
+	if cfg.LogRetention <= 0 {
+		cfg.LogRetention = config.Duration(defaultLogRetention)
+	}
+
+	return &Dispatcher{
+		db:     db,
+		cfg:    cfg,
+		client: &http.Client{Timeout: time.Duration(cfg.Timeout)},
+		logger: logger,
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+func newID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func subKey(id string) []byte {
+	return []byte(subPrefix + id)
+}
+
+func queueKey(next time.Time, id string) []byte {
+	return []byte(fmt.Sprintf("%s%019d:%s", queuePrefix, next.UnixNano(), id))
+}
+
+func deadKey(id string) []byte {
+	return []byte(deadPrefix + id)
+}
+
+// CreateSubscription adds a subscription. A random secret is generated when
+// secret is empty.
+func (d *Dispatcher) CreateSubscription(rawUrl string, events []string, secret string) (Subscription, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Subscription{}, fmt.Errorf("webhooks.go: invalid webhook url %q", rawUrl)
+	}
+	for _, event := range events {
+		if !slices.Contains(Events, event) {
+			return Subscription{}, fmt.Errorf("webhooks.go: unknown event %q", event)
+		}
+	}
+	if secret == "" {
+		secret = newID() + newID()
+	}
+
+	sub := Subscription{
+		ID:        newID(),
+		URL:       rawUrl,
+		Events:    events,
+		Secret:    secret,
+		CreatedAt: d.now(),
+	}
+	val, err := json.Marshal(sub)
+	if err != nil {
+		return Subscription{}, err
+	}
+	return sub, d.db.Put(subKey(sub.ID), val, nil)
+}
+
+// DeleteSubscription removes a subscription. Its pending deliveries are
+// dropped when they come up.
+func (d *Dispatcher) DeleteSubscription(id string) error {
+	if _, err := d.getSubscription(id); err != nil {
+		return err
+	}
+	return d.db.Delete(subKey(id), nil)
+}
+
+func (d *Dispatcher) getSubscription(id string) (Subscription, error) {
+	val, err := d.db.Get(subKey(id), nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return Subscription{}, ErrNotFound
+	}
+	if err != nil {
+		return Subscription{}, err
+	}
+	var sub Subscription
+	return sub, json.Unmarshal(val, &sub)
+}
+
+// Subscriptions lists all subscriptions, including their secrets.
+func (d *Dispatcher) Subscriptions() ([]Subscription, error) {
+	subs := []Subscription{}
+	iter := d.db.NewIterator(util.BytesPrefix([]byte(subPrefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		var sub Subscription
+		if err := json.Unmarshal(iter.Value(), &sub); err != nil {
+			d.logger.Debug("webhooks.go: skipping unreadable subscription", zap.Error(err))
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	return subs, iter.Error()
+}
+
+// Publish queues the event for every subscription that wants it. The queue is
+// in the db, so queued deliveries survive restarts.
+func (d *Dispatcher) Publish(eventType string, data any) error {
+	subs, err := d.Subscriptions()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	now := d.now()
+	event := Event{ID: newID(), Type: eventType, Timestamp: now, Data: payload}
+
+	batch := new(leveldb.Batch)
+	for _, sub := range subs {
+		if !sub.wants(eventType) {
+			continue
+		}
+		delivery := Delivery{ID: newID(), SubscriptionID: sub.ID, Event: event, NextAttempt: now}
+		val, err := json.Marshal(delivery)
+		if err != nil {
+			return err
+		}
+		batch.Put(queueKey(now, delivery.ID), val)
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	if err := d.db.Write(batch, nil); err != nil {
+		return err
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"go.uber.org/zap"
+
+	"github.com/moh-osman3/shortener/config"
+)
+
+type received struct {
+	event     Event
+	signature string
+	body      []byte
+	delivery  string
+}
+
+// receiver records the requests it gets and answers with the next status in
+// statuses, then 200 once they run out.
+type receiver struct {
+	lock     sync.Mutex
+	statuses []int
+	requests []received
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var event Event
+	json.Unmarshal(body, &event)
+
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	rc.requests = append(rc.requests, received{
+		event:     event,
+		signature: r.Header.Get(SignatureHeader),
+		body:      body,
+		delivery:  r.Header.Get("X-Shortener-Delivery"),
+	})
+	status := http.StatusOK
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (rc *receiver) all() []received {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	return append([]received{}, rc.requests...)
+}
+
+func newTestDispatcher(t *testing.T, db *leveldb.DB, cfg config.WebhooksConfig) (*Dispatcher, *time.Time) {
+	t.Helper()
+	d := New(db, cfg, zap.NewNop())
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	return d, &now
+}
+
+func newTestDB(t *testing.T) *leveldb.DB {
+	t.Helper()
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"type":"link.created"}`)
+	header := Sign("secret", now, body)
+
+	assert.NoError(t, Verify("secret", header, body, now, time.Minute))
+	assert.ErrorIs(t, Verify("other", header, body, now, time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", header, []byte(`{}`), now, time.Minute), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", header, body, now.Add(time.Hour), time.Minute), ErrInvalidSignature)
+	assert.NoError(t, Verify("secret", header, body, now.Add(time.Hour), 0))
+	assert.ErrorIs(t, Verify("secret", "garbage", body, now, 0), ErrInvalidSignature)
+}
+
+func TestCreateSubscriptionValidation(t *testing.T) {
+	d, _ := newTestDispatcher(t, newTestDB(t), config.WebhooksConfig{})
+
+	_, err := d.CreateSubscription("ftp://example.com", nil, "")
+	assert.Error(t, err)
+	_, err = d.CreateSubscription("https://example.com/hook", []string{"link.renamed"}, "")
+	assert.Error(t, err)
+
+	sub, err := d.CreateSubscription("https://example.com/hook", []string{EventCreated}, "")
+	require.NoError(t, err)
+	assert.NotEmpty(t, sub.Secret)
+
+	subs, err := d.Subscriptions()
+	require.NoError(t, err)
+	assert.Equal(t, []Subscription{sub}, subs)
+
+	assert.NoError(t, d.DeleteSubscription(sub.ID))
+	assert.ErrorIs(t, d.DeleteSubscription(sub.ID), ErrNotFound)
+}
+
+func TestDeliverSignedEvent(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	d, now := newTestDispatcher(t, newTestDB(t), config.WebhooksConfig{})
+	sub, err := d.CreateSubscription(server.URL, []string{EventCreated}, "secret")
+	require.NoError(t, err)
+
+	// the subscription doesn't want deleted events
+	require.NoError(t, d.Publish(EventDeleted, map[string]string{"id": "abc"}))
+	require.NoError(t, d.Publish(EventCreated, map[string]string{"id": "abc"}))
+	d.ProcessQueue(context.Background())
+
+	requests := rc.all()
+	require.Len(t, requests, 1)
+	assert.Equal(t, EventCreated, requests[0].event.Type)
+	assert.JSONEq(t, `{"id":"abc"}`, string(requests[0].event.Data))
+	assert.NoError(t, Verify("secret", requests[0].signature, requests[0].body, *now, time.Minute))
+
+	entries, err := d.Deliveries(sub.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, OutcomeDelivered, entries[0].Outcome)
+	assert.Equal(t, http.StatusOK, entries[0].StatusCode)
+	assert.Equal(t, requests[0].delivery, entries[0].DeliveryID)
+
+	// the queue is empty once the event is delivered
+	d.ProcessQueue(context.Background())
+	assert.Len(t, rc.all(), 1)
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	d, now := newTestDispatcher(t, newTestDB(t), config.WebhooksConfig{
+		InitialBackoff: config.Duration(10 * time.Second),
+		MaxBackoff:     config.Duration(15 * time.Second),
+	})
+	sub, err := d.CreateSubscription(server.URL, nil, "secret")
+	require.NoError(t, err)
+	require.NoError(t, d.Publish(EventExpired, map[string]string{"id": "abc"}))
+
+	d.ProcessQueue(context.Background())
+	assert.Len(t, rc.all(), 1)
+
+	// not due until the first backoff has passed
+	*now = now.Add(9 * time.Second)
+	d.ProcessQueue(context.Background())
+	assert.Len(t, rc.all(), 1)
+	*now = now.Add(time.Second)
+	d.ProcessQueue(context.Background())
+	assert.Len(t, rc.all(), 2)
+
+	// the second backoff doubles to 20s and is capped at 15s
+	*now = now.Add(15 * time.Second)
+	d.ProcessQueue(context.Background())
+	requests := rc.all()
+	require.Len(t, requests, 3)
+	assert.Equal(t, requests[0].delivery, requests[2].delivery)
+
+	entries, err := d.Deliveries(sub.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, OutcomeDelivered, entries[0].Outcome)
+	assert.Equal(t, 3, entries[0].Attempt)
+	assert.Equal(t, OutcomeRetrying, entries[1].Outcome)
+	assert.Equal(t, http.StatusBadGateway, entries[1].StatusCode)
+
+	entries, err = d.Deliveries(sub.ID, 1)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestDeadLetterAndRedeliver(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	d, now := newTestDispatcher(t, newTestDB(t), config.WebhooksConfig{
+		MaxAttempts:    2,
+		InitialBackoff: config.Duration(time.Second),
+	})
+	_, err := d.CreateSubscription(server.URL, nil, "secret")
+	require.NoError(t, err)
+	require.NoError(t, d.Publish(EventDisabled, map[string]string{"id": "abc"}))
+
+	d.ProcessQueue(context.Background())
+	*now = now.Add(time.Second)
+	d.ProcessQueue(context.Background())
+	assert.Len(t, rc.all(), 2)
+
+	dead, err := d.DeadLetters()
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, 2, dead[0].Attempts)
+	assert.Contains(t, dead[0].LastError, "500")
+
+	// dead letters are not retried
+	*now = now.Add(time.Hour)
+	d.ProcessQueue(context.Background())
+	assert.Len(t, rc.all(), 2)
+
+	assert.ErrorIs(t, d.Redeliver("missing"), ErrNotFound)
+	require.NoError(t, d.Redeliver(dead[0].ID))
+	d.ProcessQueue(context.Background())
+	assert.Len(t, rc.all(), 3)
+
+	dead, err = d.DeadLetters()
+	require.NoError(t, err)
+	assert.Empty(t, dead)
+}
+
+func TestQueueSurvivesRestart(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	db := newTestDB(t)
+	d, _ := newTestDispatcher(t, db, config.WebhooksConfig{})
+	_, err := d.CreateSubscription(server.URL, nil, "secret")
+	require.NoError(t, err)
+	require.NoError(t, d.Publish(EventCreated, map[string]string{"id": "abc"}))
+
+	// a new dispatcher on the same db picks up the queued delivery
+	restarted, _ := newTestDispatcher(t, db, config.WebhooksConfig{})
+	restarted.ProcessQueue(context.Background())
+	assert.Len(t, rc.all(), 1)
+}
+
+func TestDeletedSubscriptionDropsDeliveries(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	d, _ := newTestDispatcher(t, newTestDB(t), config.WebhooksConfig{})
+	sub, err := d.CreateSubscription(server.URL, nil, "secret")
+	require.NoError(t, err)
+	require.NoError(t, d.Publish(EventCreated, map[string]string{"id": "abc"}))
+	require.NoError(t, d.DeleteSubscription(sub.ID))
+
+	d.ProcessQueue(context.Background())
+	assert.Empty(t, rc.all())
+}
+
+func TestPruneLog(t *testing.T) {
+	rc := &receiver{}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	d, now := newTestDispatcher(t, newTestDB(t), config.WebhooksConfig{LogRetention: config.Duration(24 * time.Hour)})
+	sub, err := d.CreateSubscription(server.URL, nil, "secret")
+	require.NoError(t, err)
+	require.NoError(t, d.Publish(EventCreated, map[string]string{"id": "old"}))
+	d.ProcessQueue(context.Background())
+
+	*now = now.Add(25 * time.Hour)
+	require.NoError(t, d.Publish(EventCreated, map[string]string{"id": "new"}))
+	d.ProcessQueue(context.Background())
+
+	pruned, err := d.PruneLog()
+	require.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+
+	entries, err := d.Deliveries(sub.ID, 0)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestShutdownDuringSend(t *testing.T) {
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	db := newTestDB(t)
+	d, _ := newTestDispatcher(t, db, config.WebhooksConfig{Timeout: config.Duration(time.Minute)})
+	_, err := d.CreateSubscription(server.URL, nil, "secret")
+	require.NoError(t, err)
+	require.NoError(t, d.Publish(EventCreated, map[string]string{"id": "abc"}))
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(stop)
+	}()
+	<-arrived
+
+	// the queue isn't locked while the delivery is sent
+	redelivered := make(chan error)
+	go func() { redelivered <- d.Redeliver("missing") }()
+	select {
+	case err := <-redelivered:
+		assert.ErrorIs(t, err, ErrNotFound)
+	case <-time.After(5 * time.Second):
+		t.Fatal("redeliver blocked on the send in flight")
+	}
+
+	// stopping cancels the send and leaves the delivery queued
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return after stop")
+	}
+
+	entries, err := d.Deliveries("", 0)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	due, err := d.dueDeliveries()
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, 0, due[0].delivery.Attempts)
+}